@@ -0,0 +1,161 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// isAccepted returns whether the file descriptor at the given offset is a
+// single connection, as passed by socket units with Accept=yes.
+func isAccepted(num int) (bool, error) {
+	fd := listenFdsStart + num
+
+	ok, err := IsSocket(fd, 0, syscall.SOCK_STREAM, 0)
+	if ok || err != nil {
+		return ok, err
+	}
+	return IsSocket(fd, 0, syscall.SOCK_SEQPACKET, 0)
+}
+
+// remoteAddr returns the address of the peer of a connection passed with
+// Accept=yes. For internet sockets it is taken from REMOTE_ADDR and
+// REMOTE_PORT, with the address type matching the connection, otherwise
+// conn.RemoteAddr() is used.
+func remoteAddr(conn net.Conn) (net.Addr, error) {
+	defer consumeEnv(Remote)
+
+	e := osm.Getenv("REMOTE_ADDR")
+	if e == "" {
+		return conn.RemoteAddr(), nil
+	}
+	switch conn.(type) {
+	case *net.TCPConn, *net.UDPConn, *net.IPConn:
+	default:
+		return conn.RemoteAddr(), nil
+	}
+
+	ip := net.ParseIP(e)
+	if ip == nil {
+		return nil, fmt.Errorf("Could not parse REMOTE_ADDR \"%s\"", e)
+	}
+	if _, ok := conn.(*net.IPConn); ok {
+		return &net.IPAddr{IP: ip}, nil
+	}
+
+	e = osm.Getenv("REMOTE_PORT")
+	port, err := strconv.ParseUint(e, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse REMOTE_PORT \"%s\"", e)
+	}
+
+	if _, ok := conn.(*net.UDPConn); ok {
+		return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// AcceptedConn returns the connection passed by the system manager, if the
+// service was started by a socket unit with Accept=yes, which spawns one
+// instance of the service per connection. remote is the address of the peer.
+// If the service was not started for a single connection, conn is nil.
+func AcceptedConn() (conn net.Conn, remote net.Addr, err error) {
+	names, err := passedFds()
+	if err != nil {
+		return nil, nil, err
+	}
+	return acceptedConn(len(names))
+}
+
+// acceptedConn returns the passed connection, if the fds passed file
+// descriptors are a single connection.
+func acceptedConn(fds int) (conn net.Conn, remote net.Addr, err error) {
+	if fds != 1 {
+		return nil, nil, nil
+	}
+	return acceptedConnAt(0)
+}
+
+// acceptedConnAt returns the connection at the given offset, if the file
+// descriptor there is one.
+func acceptedConnAt(num int) (conn net.Conn, remote net.Addr, err error) {
+	ok, err := isAccepted(num)
+	if !ok || err != nil {
+		return nil, nil, err
+	}
+
+	conn, err = getConn(num)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	remote, err = remoteAddr(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, remote, nil
+}
+
+// ServeConns calls handler for connections passed by the system manager, so
+// it can be written once for socket units with both Accept=yes and Accept=no.
+// With Accept=yes, handler is called once for the passed connection. With
+// Accept=no, connections are accepted from all passed listeners and handler is
+// called in a new goroutine for each of them, until all listeners fail; the
+// first error is returned.
+func ServeConns(handler func(net.Conn)) error {
+	names, err := passedFds()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.New("No filedescriptors passed")
+	}
+
+	conn, _, err := acceptedConn(len(names))
+	if err != nil {
+		return err
+	}
+	if conn != nil {
+		handler(conn)
+		return nil
+	}
+
+	var listeners []net.Listener
+	nums := make([]int, len(names))
+	for i := range nums {
+		nums[i] = i
+	}
+	if _, err = storeFds(nums, []interface{}{&listeners}); err != nil {
+		return err
+	}
+	return serveListeners(listeners, handler)
+}
+
+// serveListeners accepts connections on all listeners and calls handler for
+// each of them in a new goroutine. It returns once all listeners failed.
+func serveListeners(listeners []net.Listener, handler func(net.Conn)) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(listeners))
+
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					errs <- err
+					return
+				}
+				go handler(c)
+			}
+		}(l)
+	}
+
+	wg.Wait()
+	return <-errs
+}
@@ -0,0 +1,168 @@
+package systemd
+
+import (
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestRemoteAddr(t *testing.T) {
+	osm = &osPackage{}
+	defer os.Unsetenv("REMOTE_ADDR")
+	defer os.Unsetenv("REMOTE_PORT")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close()
+
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "")
+	defer f.Close()
+	syscall.Close(fds[1])
+	unc, err := net.FileConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unc.Close()
+
+	var testcases = []struct {
+		Conn       net.Conn
+		RemoteAddr string
+		RemotePort string
+		Addr       net.Addr
+		Err        bool
+	}{
+		{tc, "", "", tc.RemoteAddr(), false},
+		{tc, "192.0.2.1", "4242", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4242}, false},
+		{tc, "2001:db8::1", "80", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80}, false},
+		{uc, "192.0.2.1", "53", &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}, false},
+		{unc, "192.0.2.1", "4242", unc.RemoteAddr(), false},
+		{tc, "unparseable", "80", nil, true},
+		{tc, "192.0.2.1", "", nil, true},
+		{tc, "192.0.2.1", "70000", nil, true},
+	}
+
+	for _, tc := range testcases {
+		os.Setenv("REMOTE_ADDR", tc.RemoteAddr)
+		os.Setenv("REMOTE_PORT", tc.RemotePort)
+
+		addr, err := remoteAddr(tc.Conn)
+		if tc.Err != (err != nil) {
+			t.Errorf("remoteAddr(%T) with %q:%q: unexpected error %v", tc.Conn, tc.RemoteAddr, tc.RemotePort, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(addr, tc.Addr) {
+			t.Errorf("remoteAddr(%T) with %q:%q = %#v, expected %#v", tc.Conn, tc.RemoteAddr, tc.RemotePort, addr, tc.Addr)
+		}
+	}
+}
+
+func TestAcceptedConn(t *testing.T) {
+	osm = &osPackage{}
+	os.Unsetenv("REMOTE_ADDR")
+	os.Unsetenv("REMOTE_PORT")
+
+	// pair returns one end of a socket pair of the given type, as offset to
+	// listenFdsStart, and the other end.
+	pair := func(sotype int) (int, *os.File) {
+		fds, err := syscall.Socketpair(syscall.AF_UNIX, sotype, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fds[0] - listenFdsStart, os.NewFile(uintptr(fds[1]), "")
+	}
+
+	for _, sotype := range []int{syscall.SOCK_STREAM, syscall.SOCK_SEQPACKET} {
+		num, peer := pair(sotype)
+		defer peer.Close()
+
+		conn, remote, err := acceptedConnAt(num)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn == nil {
+			t.Fatalf("Socket pair of type %d not detected as accepted connection", sotype)
+		}
+		defer conn.Close()
+		if remote == nil || remote.Network() != conn.RemoteAddr().Network() {
+			t.Errorf("Got remote address %v, expected %v", remote, conn.RemoteAddr())
+		}
+
+		if _, err = peer.Write([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 3)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "foo" {
+			t.Errorf("Got %q, %v, expected \"foo\"", buf, err)
+		}
+	}
+
+	num, peer := pair(syscall.SOCK_DGRAM)
+	defer peer.Close()
+	defer syscall.Close(num + listenFdsStart)
+	if conn, _, err := acceptedConnAt(num); conn != nil || err != nil {
+		t.Errorf("Datagram socket detected as accepted connection: %v, %v", conn, err)
+	}
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	lf := rawFd(t, l)
+	defer lf.Close()
+	if conn, _, err := acceptedConnAt(int(lf.Fd()) - listenFdsStart); conn != nil || err != nil {
+		t.Errorf("Listening socket detected as accepted connection: %v, %v", conn, err)
+	}
+
+	if conn, _, err := acceptedConn(2); conn != nil || err != nil {
+		t.Errorf("Two passed file descriptors detected as accepted connection: %v, %v", conn, err)
+	}
+}
+
+func TestServeListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- serveListeners([]net.Listener{l}, func(c net.Conn) {
+			c.Close()
+			close(handled)
+		})
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	<-handled
+
+	l.Close()
+	if err = <-done; err == nil {
+		t.Errorf("Expected error after closing listener")
+	}
+}
@@ -0,0 +1,77 @@
+package systemd
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// NotifyBarrier blocks until the system manager has processed all
+// notifications sent before. As notifications are datagrams, that are
+// processed asynchronously, a daemon that exits right after sending one might
+// otherwise have it dropped. It uses the BARRIER=1 protocol: one end of a pipe
+// is passed along and the system manager closes it once it gets to the
+// message.
+func NotifyBarrier(ctx context.Context) error {
+	return defaultNotifier.Barrier(ctx)
+}
+
+// Barrier blocks until the system manager has processed all notifications
+// sent before, like NotifyBarrier.
+func (n *Notifier) Barrier(ctx context.Context) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = n.Send(ctx, &Message{Fields: []Field{{"BARRIER", "1"}}, Files: []*os.File{w}})
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	// The system manager never writes to the pipe, it only closes it.
+	var buf [1]byte
+	for {
+		_, err = r.Read(buf[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
+
+// NotifySync works like Notify, but blocks until the system manager has
+// processed the message, see NotifyBarrier.
+func NotifySync(ctx context.Context, state string) error {
+	if err := Notify(state); err != nil {
+		return err
+	}
+	return NotifyBarrier(ctx)
+}
+
+// SendSync works like Send, but blocks until the system manager has processed
+// the notification, see NotifyBarrier.
+func (n *Notification) SendSync(ctx context.Context) error {
+	if err := n.Send(); err != nil {
+		return err
+	}
+	return NotifyBarrier(ctx)
+}
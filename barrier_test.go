@@ -0,0 +1,53 @@
+package systemd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNotifySync(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	done := make(chan error)
+	go func() {
+		done <- NotifySync(context.Background(), "STATUS=exiting")
+	}()
+
+	if msg, _ := readNotify(t, conn); msg != "STATUS=exiting" {
+		t.Errorf("Got %q", msg)
+	}
+	msg, files := readNotify(t, conn)
+	if msg != "BARRIER=1" || len(files) != 1 {
+		t.Fatalf("Got %q with %d files", msg, len(files))
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("NotifySync returned %v before barrier was closed", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	files[0].Close()
+	if err := <-done; err != nil {
+		t.Errorf("NotifySync returned %v", err)
+	}
+}
+
+func TestNotifyBarrierCancel(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := NotifyBarrier(ctx); err != context.DeadlineExceeded {
+		t.Errorf("NotifyBarrier returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	_, files := readNotify(t, conn)
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,190 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldError is returned by BindPassedFiles, if a field could not be
+// populated.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("Could not bind field %s: %v", e.Field, e.Err)
+}
+
+// bindTag is the parsed form of a `systemd:"..."` struct tag.
+type bindTag struct {
+	name     string
+	index    int
+	optional bool
+}
+
+// parseBindTag parses a struct tag of the form "name=<name>" or
+// "index=<n>", optionally followed by ",optional".
+func parseBindTag(tag string) (t bindTag, err error) {
+	t.index = -1
+	haveName := false
+
+	for _, opt := range strings.Split(tag, ",") {
+		switch {
+		case opt == "optional":
+			t.optional = true
+		case strings.HasPrefix(opt, "name="):
+			t.name = opt[len("name="):]
+			if t.name == "" {
+				return t, errors.New("Empty name in tag")
+			}
+			haveName = true
+		case strings.HasPrefix(opt, "index="):
+			t.index, err = strconv.Atoi(opt[len("index="):])
+			if err != nil || t.index < 0 {
+				return t, fmt.Errorf("Invalid index in tag %q", opt)
+			}
+		default:
+			return t, fmt.Errorf("Unknown tag option %q", opt)
+		}
+	}
+
+	if haveName == (t.index >= 0) {
+		return t, errors.New("Tag needs exactly one of name= or index=")
+	}
+	return t, nil
+}
+
+// BindPassedFiles populates the struct pointed to by v with file descriptors
+// passed by the system manager. Every field that should receive a file
+// descriptor needs a tag, that selects it either by its name (see
+// PassedFileNames) or by its position:
+//
+//		var sockets struct {
+//			HTTP    net.Listener      `systemd:"name=http"`
+//			DNS     []*net.UDPConn    `systemd:"name=dns"`
+//			Control *net.UnixListener `systemd:"index=0"`
+//			Log     *os.File          `systemd:"name=log,optional"`
+//		}
+//		err := BindPassedFiles(&sockets)
+//
+// A field can be of any type supported by GetPassedFiles. If it is a slice, it
+// receives all file descriptors with the given name, or all file descriptors
+// starting at the given index respectively. Otherwise there must be exactly
+// one file descriptor with the given name. It is an error if no file
+// descriptor matches a field, unless the tag includes "optional". Fields
+// without a tag are left alone.
+//
+// If a field can not be populated, a *FieldError is returned and the fields
+// populated so far are closed and reset.
+func BindPassedFiles(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Target must be a pointer to a struct, not %T", v)
+	}
+	rv = rv.Elem()
+
+	names, err := passedFds()
+	if err != nil {
+		return err
+	}
+
+	var bound []reflect.Value
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, ok := sf.Tag.Lookup("systemd")
+		if !ok {
+			continue
+		}
+
+		err = bindField(rv.Field(i), tag, names)
+		if err != nil {
+			for _, f := range bound {
+				unbind(f)
+			}
+			return &FieldError{sf.Name, err}
+		}
+		bound = append(bound, rv.Field(i))
+	}
+	return nil
+}
+
+// unbind closes the files stored in f by bindField and resets it to its zero
+// value, if it is settable.
+func unbind(f reflect.Value) {
+	if f.Kind() == reflect.Slice {
+		for i := 0; i < f.Len(); i++ {
+			unbind(f.Index(i))
+		}
+	} else if !f.IsZero() {
+		if c, ok := f.Interface().(io.Closer); ok {
+			c.Close()
+		}
+	}
+	if f.CanSet() {
+		f.Set(reflect.Zero(f.Type()))
+	}
+}
+
+// bindField stores the file descriptors selected by tag in f.
+func bindField(f reflect.Value, tag string, names []string) error {
+	t, err := parseBindTag(tag)
+	if err != nil {
+		return err
+	}
+
+	if !f.CanSet() {
+		return errors.New("Field is not exported")
+	}
+
+	var nums []int
+	if t.index >= 0 {
+		for i := t.index; i < len(names); i++ {
+			nums = append(nums, i)
+		}
+	} else {
+		for i, name := range names {
+			if name == t.name {
+				nums = append(nums, i)
+			}
+		}
+	}
+
+	if len(nums) == 0 {
+		if t.optional {
+			return nil
+		}
+		if t.index >= 0 {
+			return fmt.Errorf("No filedescriptor with index %d", t.index)
+		}
+		return fmt.Errorf("No filedescriptor named %q", t.name)
+	}
+
+	if f.Kind() == reflect.Slice {
+		s := reflect.MakeSlice(f.Type(), len(nums), len(nums))
+		for i, num := range nums {
+			err = storeFd(num, s.Index(i))
+			if err != nil {
+				unbind(s)
+				return fmt.Errorf("Filedescriptor %d: %v", listenFdsStart+num, err)
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+
+	if t.index < 0 && len(nums) > 1 {
+		return fmt.Errorf("%d filedescriptors named %q, but field is not a slice", len(nums), t.name)
+	}
+
+	err = storeFd(nums[0], f)
+	if err != nil {
+		return fmt.Errorf("Filedescriptor %d: %v", listenFdsStart+nums[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseBindTag(t *testing.T) {
+
+	var testcases = []struct {
+		Tag string
+		Out bindTag
+		Err bool
+	}{
+		{Tag: "name=http", Out: bindTag{name: "http", index: -1}},
+		{Tag: "index=2", Out: bindTag{index: 2}},
+		{Tag: "name=log,optional", Out: bindTag{name: "log", index: -1, optional: true}},
+		{Tag: "", Err: true},
+		{Tag: "optional", Err: true},
+		{Tag: "name=", Err: true},
+		{Tag: "index=-1", Err: true},
+		{Tag: "index=foo", Err: true},
+		{Tag: "name=http,index=1", Err: true},
+		{Tag: "name=http,foo", Err: true},
+	}
+
+	for _, tc := range testcases {
+		out, err := parseBindTag(tc.Tag)
+		if tc.Err != (err != nil) {
+			t.Errorf("parseBindTag(%q): unexpected error %v", tc.Tag, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(out, tc.Out) {
+			t.Errorf("parseBindTag(%q) = %+v, expected %+v", tc.Tag, out, tc.Out)
+		}
+	}
+}
+
+func TestBindField(t *testing.T) {
+	names := []string{"http", "log", "log"}
+
+	fifo := func(fd int) []mockedCall {
+		return []mockedCall{
+			{"Fstat", []interface{}{fd}, []interface{}{syscall.Stat_t{Mode: syscall.S_IFIFO}, syscall.Errno(0)}},
+			{"Dup", []interface{}{fd}, []interface{}{1000 + fd, syscall.Errno(0)}},
+			{"NewFile", []interface{}{uintptr(1000 + fd), ""}, []interface{}{os.NewFile(uintptr(1000+fd), "")}},
+			{"Close", []interface{}{fd}, []interface{}{syscall.Errno(0)}},
+		}
+	}
+
+	var s struct {
+		Single   *os.File
+		Multi    []*os.File
+		Listener net.Listener
+		private  *os.File
+	}
+	rv := reflect.ValueOf(&s).Elem()
+
+	osm = &mock{}
+	if err := bindField(rv.FieldByName("Single"), "name=control,optional", names); err != nil || s.Single != nil {
+		t.Errorf("Optional field: got %v, %v", s.Single, err)
+	}
+	if err := bindField(rv.FieldByName("Single"), "name=control", names); err == nil {
+		t.Errorf("Expected error for missing file descriptor")
+	}
+	if err := bindField(rv.FieldByName("Single"), "name=log", names); err == nil {
+		t.Errorf("Expected error for several file descriptors in non-slice field")
+	}
+	if err := bindField(rv.FieldByName("private"), "name=http", names); err == nil {
+		t.Errorf("Expected error for unexported field")
+	}
+
+	m := mock(fifo(3))
+	osm = &m
+	if err := bindField(rv.FieldByName("Single"), "name=http", names); err != nil || s.Single == nil {
+		t.Errorf("Single field: got %v, %v", s.Single, err)
+	}
+
+	m = mock(append(fifo(4), fifo(5)...))
+	osm = &m
+	if err := bindField(rv.FieldByName("Multi"), "name=log", names); err != nil || len(s.Multi) != 2 {
+		t.Errorf("Slice field: got %v, %v", s.Multi, err)
+	}
+
+	calls := append(fifo(4), mockedCall{"Fstat", []interface{}{5}, []interface{}{syscall.Stat_t{Mode: syscall.S_IFSOCK}, syscall.Errno(0)}})
+	adopted := calls[2].Return[0].(*os.File)
+	m = mock(calls)
+	osm = &m
+	s.Multi = nil
+	if err := bindField(rv.FieldByName("Multi"), "name=log", names); err == nil || s.Multi != nil {
+		t.Errorf("Expected error for socket in slice field, got %v, %v", s.Multi, err)
+	}
+	if adopted.Fd() != ^uintptr(0) {
+		t.Errorf("File adopted before the error was not closed")
+	}
+
+	m = mock{
+		{"Dup", []interface{}{4}, []interface{}{1004, syscall.Errno(0)}},
+		{"NewFile", []interface{}{uintptr(1004), ""}, []interface{}{os.NewFile(1004, "")}},
+	}
+	osm = &m
+	if err := bindField(rv.FieldByName("Listener"), "index=1", names); err == nil {
+		t.Errorf("Expected error for fifo in net.Listener")
+	}
+}
+
+func TestBindPassedFilesInvalid(t *testing.T) {
+	var s struct{}
+	if err := BindPassedFiles(s); err == nil {
+		t.Errorf("Expected error for non-pointer")
+	}
+}
+
+func TestUnbind(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s struct {
+		Files []*os.File
+		Conn  net.Conn
+	}
+	s.Files = []*os.File{r, nil, w}
+	rv := reflect.ValueOf(&s).Elem()
+
+	unbind(rv.FieldByName("Files"))
+	unbind(rv.FieldByName("Conn"))
+	if s.Files != nil {
+		t.Errorf("Field not reset: %v", s.Files)
+	}
+	if r.Fd() != ^uintptr(0) || w.Fd() != ^uintptr(0) {
+		t.Errorf("Files not closed")
+	}
+}
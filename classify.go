@@ -0,0 +1,199 @@
+package systemd
+
+import (
+	"syscall"
+)
+
+// This file implements the sd_is_* family of functions, that check what kind
+// of file a file descriptor refers to. They can be used to validate that the
+// system manager actually passed what the unit file is expected to configure.
+// Like their C counterparts, they take a raw file descriptor; for passed files
+// that is listenFdsStart plus the position, see PassedFileNames.
+
+// statIsFifo returns whether st describes a FIFO.
+func statIsFifo(st *syscall.Stat_t) bool {
+	return st.Mode&syscall.S_IFMT == syscall.S_IFIFO
+}
+
+// statIsSpecial returns whether st describes a regular file or a character
+// device.
+func statIsSpecial(st *syscall.Stat_t) bool {
+	return st.Mode&syscall.S_IFMT == syscall.S_IFREG || st.Mode&syscall.S_IFMT == syscall.S_IFCHR
+}
+
+// sameFile returns whether st describes the file at path. If path does not
+// exist, that is not an error.
+func sameFile(st *syscall.Stat_t, path string) (bool, error) {
+	var stPath syscall.Stat_t
+	err := syscall.Stat(path, &stPath)
+	if err != nil {
+		if err == syscall.ENOENT || err == syscall.ENOTDIR {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if st.Mode&syscall.S_IFMT == syscall.S_IFCHR {
+		return stPath.Mode&syscall.S_IFMT == syscall.S_IFCHR && st.Rdev == stPath.Rdev, nil
+	}
+	return st.Dev == stPath.Dev && st.Ino == stPath.Ino, nil
+}
+
+// IsFifo returns whether fd is a FIFO. If path is not empty, it is also
+// checked, whether fd refers to the FIFO at that path.
+func IsFifo(fd int, path string) (bool, error) {
+	var st syscall.Stat_t
+	err := osm.Fstat(fd, &st)
+	if err != nil {
+		return false, err
+	}
+
+	if !statIsFifo(&st) {
+		return false, nil
+	}
+	if path == "" {
+		return true, nil
+	}
+	return sameFile(&st, path)
+}
+
+// IsSpecial returns whether fd is a special file, i.e. a regular file or a
+// character device (e.g. a file in /proc or /sys). If path is not empty, it
+// is also checked, whether fd refers to the file at that path.
+func IsSpecial(fd int, path string) (bool, error) {
+	var st syscall.Stat_t
+	err := osm.Fstat(fd, &st)
+	if err != nil {
+		return false, err
+	}
+
+	if !statIsSpecial(&st) {
+		return false, nil
+	}
+	if path == "" {
+		return true, nil
+	}
+	return sameFile(&st, path)
+}
+
+// isSocket checks whether fd is a socket of the given type (which is ignored
+// if it is 0) and listening state (which is ignored if it is negative, must
+// be 0 for non-listening and positive for listening sockets).
+func isSocket(fd, sotype, listening int) (bool, error) {
+	var st syscall.Stat_t
+	err := osm.Fstat(fd, &st)
+	if err != nil {
+		return false, err
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFSOCK {
+		return false, nil
+	}
+
+	if sotype != 0 {
+		t, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+		if err != nil {
+			return false, err
+		}
+		if t != sotype {
+			return false, nil
+		}
+	}
+
+	if listening >= 0 {
+		l, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+		if err != nil {
+			return false, err
+		}
+		if (l != 0) != (listening > 0) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// IsSocket returns whether fd is a socket of the given family (e.g.
+// syscall.AF_INET), type (e.g. syscall.SOCK_STREAM) and listening state. If
+// family or sotype is 0, they are not checked. If listening is negative, the
+// listening state is not checked, otherwise 0 requires a non-listening and a
+// positive value a listening socket.
+func IsSocket(fd, family, sotype, listening int) (bool, error) {
+	ok, err := isSocket(fd, sotype, listening)
+	if !ok || err != nil || family == 0 {
+		return ok, err
+	}
+
+	f, err := sockFamily(fd)
+	if err != nil {
+		return false, err
+	}
+	return f == family, nil
+}
+
+// IsSocketInet works like IsSocket, but additionally checks that fd is an
+// internet socket. family must be 0, syscall.AF_INET or syscall.AF_INET6. If
+// port is not 0, it is also checked that the socket is bound to that port.
+func IsSocketInet(fd, family, sotype, listening int, port uint16) (bool, error) {
+	if family != 0 && family != syscall.AF_INET && family != syscall.AF_INET6 {
+		return false, syscall.EINVAL
+	}
+
+	ok, err := isSocket(fd, sotype, listening)
+	if !ok || err != nil {
+		return ok, err
+	}
+
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return false, err
+	}
+
+	var p int
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		if family == syscall.AF_INET6 {
+			return false, nil
+		}
+		p = sa.Port
+	case *syscall.SockaddrInet6:
+		if family == syscall.AF_INET {
+			return false, nil
+		}
+		p = sa.Port
+	default:
+		return false, nil
+	}
+
+	return port == 0 || int(port) == p, nil
+}
+
+// IsSocketUnix works like IsSocket, but additionally checks that fd is a unix
+// domain socket. If path is not empty, it is also checked that the socket is
+// bound to that path. Abstract socket addresses start with '@'.
+func IsSocketUnix(fd, sotype, listening int, path string) (bool, error) {
+	ok, err := isSocket(fd, sotype, listening)
+	if !ok || err != nil {
+		return ok, err
+	}
+
+	f, err := sockFamily(fd)
+	if err != nil {
+		return false, err
+	}
+	if f != syscall.AF_UNIX {
+		return false, nil
+	}
+	if path == "" {
+		return true, nil
+	}
+
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return false, err
+	}
+	su, ok := sa.(*syscall.SockaddrUnix)
+	if !ok {
+		return false, nil
+	}
+	return su.Name == path, nil
+}
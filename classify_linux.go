@@ -0,0 +1,50 @@
+package systemd
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// sockFamily returns the address family of the socket fd.
+func sockFamily(fd int) (int, error) {
+	return syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+}
+
+// mqAttr corresponds to struct mq_attr.
+type mqAttr struct {
+	Flags   int
+	Maxmsg  int
+	Msgsize int
+	Curmsgs int
+	_       [4]int
+}
+
+// IsMQ returns whether fd is a POSIX message queue. If path is not empty, it is
+// also checked that fd refers to the message queue of that name (which must
+// start with a '/').
+func IsMQ(fd int, path string) (bool, error) {
+	attr := new(mqAttr)
+	_, _, errno := osm.Syscall(syscall.SYS_MQ_GETSETATTR, uintptr(fd), 0, uintptr(unsafe.Pointer(attr)))
+	runtime.KeepAlive(attr)
+	if errno == syscall.EBADF {
+		return false, nil
+	}
+	if errno != 0 {
+		return false, errno
+	}
+
+	if path == "" {
+		return true, nil
+	}
+	if path[0] != '/' {
+		return false, syscall.EINVAL
+	}
+
+	var st syscall.Stat_t
+	err := osm.Fstat(fd, &st)
+	if err != nil {
+		return false, err
+	}
+	return sameFile(&st, "/dev/mqueue"+path)
+}
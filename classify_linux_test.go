@@ -0,0 +1,21 @@
+package systemd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsMQPipe(t *testing.T) {
+	osm = &osPackage{}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	if ok, err := IsMQ(int(r.Fd()), ""); ok || err != nil {
+		t.Errorf("IsMQ(pipe) = %v, %v, expected false, <nil>", ok, err)
+	}
+}
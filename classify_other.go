@@ -0,0 +1,36 @@
+//go:build !linux
+
+package systemd
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errUnsupported is returned by functions, that need features only available
+// on Linux.
+var errUnsupported = errors.New("Not supported on this platform")
+
+// sockFamily returns the address family of the socket fd. Without SO_DOMAIN,
+// it is derived from the address the socket is bound to.
+func sockFamily(fd int) (int, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return 0, err
+	}
+	switch sa.(type) {
+	case *syscall.SockaddrInet4:
+		return syscall.AF_INET, nil
+	case *syscall.SockaddrInet6:
+		return syscall.AF_INET6, nil
+	case *syscall.SockaddrUnix:
+		return syscall.AF_UNIX, nil
+	}
+	return 0, nil
+}
+
+// IsMQ returns whether fd is a POSIX message queue. Message queues are only
+// supported on Linux.
+func IsMQ(fd int, path string) (bool, error) {
+	return false, errUnsupported
+}
@@ -0,0 +1,140 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// rawFd returns the file descriptor of a socket created by net.
+func rawFd(t *testing.T, c interface {
+	File() (*os.File, error)
+}) *os.File {
+	f, err := c.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestIsFifoSpecial(t *testing.T) {
+	osm = &osPackage{}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var testcases = []struct {
+		Name    string
+		F       func(int, string) (bool, error)
+		Fd      int
+		Path    string
+		Outcome bool
+	}{
+		{"IsFifo", IsFifo, int(r.Fd()), "", true},
+		{"IsFifo", IsFifo, int(f.Fd()), "", false},
+		{"IsSpecial", IsSpecial, int(f.Fd()), "", true},
+		{"IsSpecial", IsSpecial, int(f.Fd()), path, true},
+		{"IsSpecial", IsSpecial, int(f.Fd()), "/proc/self/status", false},
+		{"IsSpecial", IsSpecial, int(f.Fd()), path + ".missing", false},
+		{"IsSpecial", IsSpecial, int(r.Fd()), "", false},
+	}
+
+	for _, tc := range testcases {
+		ok, err := tc.F(tc.Fd, tc.Path)
+		if err != nil {
+			t.Errorf("%s(%d, %q): %v", tc.Name, tc.Fd, tc.Path, err)
+		} else if ok != tc.Outcome {
+			t.Errorf("%s(%d, %q) = %v, expected %v", tc.Name, tc.Fd, tc.Path, ok, tc.Outcome)
+		}
+	}
+}
+
+func TestIsSocket(t *testing.T) {
+	osm = &osPackage{}
+
+	tl, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	tf := rawFd(t, tl)
+	defer tf.Close()
+	port := uint16(tl.Addr().(*net.TCPAddr).Port)
+
+	uc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	uf := rawFd(t, uc)
+	defer uf.Close()
+
+	path := filepath.Join(t.TempDir(), "sock")
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	ulf := rawFd(t, ul)
+	defer ulf.Close()
+
+	tfd, ufd, ulfd := int(tf.Fd()), int(uf.Fd()), int(ulf.Fd())
+
+	check := func(desc string, ok bool, err error, expected bool) {
+		if err != nil {
+			t.Errorf("%s: %v", desc, err)
+		} else if ok != expected {
+			t.Errorf("%s = %v, expected %v", desc, ok, expected)
+		}
+	}
+
+	ok, err := IsSocket(tfd, 0, 0, -1)
+	check("IsSocket(tcp)", ok, err, true)
+	ok, err = IsSocket(tfd, syscall.AF_INET, syscall.SOCK_STREAM, 1)
+	check("IsSocket(tcp, AF_INET, SOCK_STREAM, listening)", ok, err, true)
+	ok, err = IsSocket(tfd, syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	check("IsSocket(tcp, AF_INET, SOCK_STREAM, not listening)", ok, err, false)
+	ok, err = IsSocket(tfd, syscall.AF_UNIX, 0, -1)
+	check("IsSocket(tcp, AF_UNIX)", ok, err, false)
+	ok, err = IsSocket(ufd, 0, syscall.SOCK_STREAM, -1)
+	check("IsSocket(udp, SOCK_STREAM)", ok, err, false)
+
+	ok, err = IsSocketInet(tfd, syscall.AF_INET, syscall.SOCK_STREAM, 1, port)
+	check("IsSocketInet(tcp, port)", ok, err, true)
+	ok, err = IsSocketInet(tfd, 0, 0, -1, port+1)
+	check("IsSocketInet(tcp, wrong port)", ok, err, false)
+	ok, err = IsSocketInet(tfd, syscall.AF_INET6, 0, -1, 0)
+	check("IsSocketInet(tcp, AF_INET6)", ok, err, false)
+	ok, err = IsSocketInet(ufd, syscall.AF_INET, syscall.SOCK_DGRAM, 0, 0)
+	check("IsSocketInet(udp)", ok, err, true)
+	ok, err = IsSocketInet(ulfd, 0, 0, -1, 0)
+	check("IsSocketInet(unix)", ok, err, false)
+	if _, err = IsSocketInet(tfd, syscall.AF_UNIX, 0, -1, 0); err == nil {
+		t.Errorf("IsSocketInet(AF_UNIX): expected error")
+	}
+
+	ok, err = IsSocketUnix(ulfd, syscall.SOCK_STREAM, 1, path)
+	check("IsSocketUnix(unix, path)", ok, err, true)
+	ok, err = IsSocketUnix(ulfd, 0, -1, path+".other")
+	check("IsSocketUnix(unix, wrong path)", ok, err, false)
+	ok, err = IsSocketUnix(tfd, 0, -1, "")
+	check("IsSocketUnix(tcp)", ok, err, false)
+
+	ok, err = IsFifo(tfd, "")
+	check("IsFifo(tcp)", ok, err, false)
+	ok, err = IsSpecial(tfd, "")
+	check("IsSpecial(tcp)", ok, err, false)
+}
@@ -0,0 +1,91 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// NotifyWithFiles works like Notify, but additionally passes the given files
+// to the system manager. This is mostly useful together with FDSTORE=1, see
+// StoreFiles.
+func NotifyWithFiles(state string, files ...*os.File) error {
+	return defaultNotifier.NotifyWithFiles(context.Background(), state, files...)
+}
+
+// checkFdName checks whether name is a valid name for a stored file
+// descriptor, i.e. at most 255 printable ASCII characters, not containing a
+// colon.
+func checkFdName(name string) error {
+	if name == "" || len(name) > 255 {
+		return fmt.Errorf("Invalid length of file descriptor name %q", name)
+	}
+	for _, c := range name {
+		if c < ' ' || c > '~' || c == ':' {
+			return fmt.Errorf("Invalid character %q in file descriptor name %q", c, name)
+		}
+	}
+	return nil
+}
+
+// StoreFiles pushes the given files into the file descriptor store of the
+// system manager (see FileDescriptorStoreMax= in systemd.service), under the
+// given name. They are passed back to the service on its next start and can
+// be retrieved with GetStoredFiles or GetPassedFilesByName. If one of the
+// files is a socket and it encounters an error or hangup, the system manager
+// removes it from the store; use StoreFilesNoPoll to prevent that.
+func StoreFiles(name string, files ...*os.File) error {
+	return storeFiles(name, nil, files)
+}
+
+// StoreFilesNoPoll works like StoreFiles, but tells the system manager not to
+// poll the files for errors (FDPOLL=0).
+func StoreFilesNoPoll(name string, files ...*os.File) error {
+	return storeFiles(name, []Field{{"FDPOLL", "0"}}, files)
+}
+
+func storeFiles(name string, extra []Field, files []*os.File) error {
+	if len(files) == 0 {
+		return errors.New("No files to store")
+	}
+	m := &Message{
+		Fields: append([]Field{{"FDSTORE", "1"}, {"FDNAME", name}}, extra...),
+		Files:  files,
+	}
+	return defaultNotifier.Send(context.Background(), m)
+}
+
+// RemoveStoredFiles tells the system manager to close and remove all files
+// stored under the given name from the file descriptor store.
+func RemoveStoredFiles(name string) error {
+	m := &Message{Fields: []Field{{"FDSTOREREMOVE", "1"}, {"FDNAME", name}}}
+	return defaultNotifier.Send(context.Background(), m)
+}
+
+// GetStoredFiles returns the files that were stored under the given name with
+// StoreFiles before the service was restarted. Contrary to GetPassedFiles,
+// no checks are done on the type of the files.
+func GetStoredFiles(name string) ([]*os.File, error) {
+	names, err := passedFds()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*os.File
+	for i, nm := range names {
+		if nm == name {
+			err = adopt(i, name, func(f *os.File) error {
+				files = append(files, f)
+				return nil
+			})
+			if err != nil {
+				for _, f := range files {
+					f.Close()
+				}
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
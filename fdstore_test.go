@@ -0,0 +1,79 @@
+package systemd
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckFdName(t *testing.T) {
+	var testcases = []struct {
+		Name string
+		Err  bool
+	}{
+		{"http", false},
+		{"stored-conn.42", false},
+		{"", true},
+		{"foo:bar", true},
+		{"foo\nbar", true},
+		{"föö", true},
+		{strings.Repeat("x", 256), true},
+	}
+
+	for _, tc := range testcases {
+		if err := checkFdName(tc.Name); tc.Err != (err != nil) {
+			t.Errorf("checkFdName(%q): unexpected error %v", tc.Name, err)
+		}
+	}
+}
+
+func TestStoreFiles(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	if err = StoreFiles("pipe", r, w); err != nil {
+		t.Fatal(err)
+	}
+	msg, files := readNotify(t, conn)
+	if msg != "FDSTORE=1\nFDNAME=pipe" {
+		t.Errorf("Got message %q", msg)
+	}
+	if len(files) != 2 {
+		t.Errorf("Got %d files, expected 2", len(files))
+	}
+	for _, f := range files {
+		f.Close()
+	}
+
+	if err = StoreFilesNoPoll("pipe", r); err != nil {
+		t.Fatal(err)
+	}
+	msg, files = readNotify(t, conn)
+	if msg != "FDSTORE=1\nFDNAME=pipe\nFDPOLL=0" || len(files) != 1 {
+		t.Errorf("Got message %q with %d files", msg, len(files))
+	}
+	for _, f := range files {
+		f.Close()
+	}
+
+	if err = RemoveStoredFiles("pipe"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ = readNotify(t, conn); msg != "FDSTOREREMOVE=1\nFDNAME=pipe" {
+		t.Errorf("Got message %q", msg)
+	}
+
+	if err = StoreFiles("pipe"); err == nil {
+		t.Errorf("Expected error when storing no files")
+	}
+	if err = StoreFiles("in:valid", r); err == nil {
+		t.Errorf("Expected error for invalid name")
+	}
+}
@@ -0,0 +1,196 @@
+// Package journal sends log entries to the systemd journal, using its native
+// protocol. Contrary to logging to stderr, this keeps the priority of an
+// entry, multi-line messages and additional structured fields:
+//
+//		journal.Send("Request failed", journal.PriErr, map[string]string{
+//			"REQUEST_ID": id,
+//			"BODY":       string(body),
+//		})
+//
+// See systemd.journal-fields(7) for the fields interpreted by the journal.
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Priority is the syslog priority of a journal entry.
+type Priority int
+
+// The priorities of journal entries, from the most to the least severe.
+const (
+	PriEmerg Priority = iota
+	PriAlert
+	PriCrit
+	PriErr
+	PriWarning
+	PriNotice
+	PriInfo
+	PriDebug
+)
+
+// socketPath is the socket of the journal.
+var socketPath = "/run/systemd/journal/socket"
+
+var (
+	mtx  sync.Mutex
+	conn *net.UnixConn
+)
+
+// Enabled returns whether the journal is available.
+func Enabled() bool {
+	fi, err := os.Stat(socketPath)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// Print sends a message with the given priority to the journal, formatted
+// like fmt.Sprintf.
+func Print(priority Priority, format string, args ...interface{}) error {
+	return Send(fmt.Sprintf(format, args...), priority, nil)
+}
+
+// Send sends a message with the given priority and additional fields to the
+// journal. Field names must consist of uppercase letters, digits and
+// underscores, must not start with an underscore or digit and may be at most
+// 64 characters long. Values may contain arbitrary data, including newlines.
+func Send(message string, priority Priority, fields map[string]string) error {
+	if priority < PriEmerg || priority > PriDebug {
+		return fmt.Errorf("Invalid priority %d", priority)
+	}
+
+	var buf bytes.Buffer
+	appendField(&buf, "MESSAGE", message)
+	appendField(&buf, "PRIORITY", fmt.Sprint(int(priority)))
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if err := checkFieldName(k); err != nil {
+			return err
+		}
+		if k == "MESSAGE" || k == "PRIORITY" {
+			return fmt.Errorf("Field %s must be passed as an argument", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		appendField(&buf, k, fields[k])
+	}
+
+	return send(buf.Bytes())
+}
+
+// checkFieldName checks whether name is a valid name of a field, that can be
+// set by a client.
+func checkFieldName(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("Invalid length of field name %q", name)
+	}
+	if name[0] == '_' {
+		return fmt.Errorf("Field name %q is reserved for trusted fields", name)
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		return fmt.Errorf("Field name %q starts with a digit", name)
+	}
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return fmt.Errorf("Invalid character %q in field name %q", c, name)
+		}
+	}
+	return nil
+}
+
+// appendField appends a field in the native protocol to buf. Values with
+// newlines are sent in the binary-safe format: the name, a newline, the
+// length of the value as a little-endian uint64, the value and a newline.
+func appendField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// send sends an encoded entry to the journal. Entries that are too large for
+// a single datagram are written to a sealed memfd, which is passed instead. If
+// sending fails, the socket is closed, so the next call starts with a new one.
+func send(entry []byte) error {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	if conn == nil {
+		c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+		if err != nil {
+			return err
+		}
+		conn = c
+	}
+
+	addr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+	_, _, err := conn.WriteMsgUnix(entry, nil, addr)
+	if isTooLarge(err) {
+		err = sendFile(entry, addr)
+	}
+	if err != nil {
+		conn.Close()
+		conn = nil
+	}
+	return err
+}
+
+// sendFile sends entry to addr in a file, via conn.
+func sendFile(entry []byte, addr *net.UnixAddr) error {
+	f, err := tempFile(entry)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, _, err = conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), addr)
+	return err
+}
+
+// isTooLarge returns whether err says, that a datagram was too large.
+func isTooLarge(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.EMSGSIZE || errno == syscall.ENOBUFS
+}
+
+// tempFile returns a file containing b, to be passed to the journal. It is a
+// sealed memfd if possible, or an unlinked file in /dev/shm otherwise.
+func tempFile(b []byte) (*os.File, error) {
+	f, err := memfd(b)
+	if err == nil {
+		return f, nil
+	}
+
+	f, err = os.CreateTemp("/dev/shm", "journal.")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,188 @@
+package journal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCheckFieldName(t *testing.T) {
+	var testcases = []struct {
+		Name string
+		Err  bool
+	}{
+		{"REQUEST_ID", false},
+		{"X1", false},
+		{"", true},
+		{"_PID", true},
+		{"1FOO", true},
+		{"foo", true},
+		{"FOO-BAR", true},
+		{"FOO=BAR", true},
+		{strings.Repeat("X", 64), false},
+		{strings.Repeat("X", 65), true},
+	}
+
+	for _, tc := range testcases {
+		if err := checkFieldName(tc.Name); tc.Err != (err != nil) {
+			t.Errorf("checkFieldName(%q): unexpected error %v", tc.Name, err)
+		}
+	}
+}
+
+func TestAppendField(t *testing.T) {
+	var testcases = []struct {
+		Name  string
+		Value string
+		Out   string
+	}{
+		{"FOO", "bar", "FOO=bar\n"},
+		{"FOO", "", "FOO=\n"},
+		{"FOO", "a=b", "FOO=a=b\n"},
+		{"FOO", "a\nb", "FOO\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n"},
+	}
+
+	for _, tc := range testcases {
+		var buf bytes.Buffer
+		appendField(&buf, tc.Name, tc.Value)
+		if buf.String() != tc.Out {
+			t.Errorf("appendField(%q, %q) = %q, expected %q", tc.Name, tc.Value, buf.String(), tc.Out)
+		}
+	}
+}
+
+// fakeJournal creates a socket and points socketPath at it.
+func fakeJournal(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "socket")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := socketPath
+	socketPath = path
+	t.Cleanup(func() {
+		socketPath = old
+		c.Close()
+	})
+	return c
+}
+
+// readEntry reads an entry from c, including entries passed as a file.
+func readEntry(t *testing.T, c *net.UnixConn) string {
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, 1024)
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, oobn, _, _, err := c.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oobn == 0 {
+		return string(buf[:n])
+	}
+
+	cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(cmsgs) != 1 {
+		t.Fatalf("Parsing control messages: %v", err)
+	}
+	fds, err := syscall.ParseUnixRights(&cmsgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("Parsing rights: %v", err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "entry")
+	defer f.Close()
+
+	if n != 0 {
+		t.Errorf("Got %d bytes of data along with a file", n)
+	}
+	if _, err = f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSend(t *testing.T) {
+	c := fakeJournal(t)
+
+	if !Enabled() {
+		t.Errorf("Enabled() = false, expected true")
+	}
+
+	err := Send("hello\nworld", PriWarning, map[string]string{"ZZZ": "last", "REQUEST_ID": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "MESSAGE\n\x0b\x00\x00\x00\x00\x00\x00\x00hello\nworld\nPRIORITY=4\nREQUEST_ID=42\nZZZ=last\n"
+	if got := readEntry(t, c); got != want {
+		t.Errorf("Got %q, expected %q", got, want)
+	}
+
+	if err = Print(PriInfo, "%d items", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEntry(t, c); got != "MESSAGE=3 items\nPRIORITY=6\n" {
+		t.Errorf("Got %q", got)
+	}
+
+	if err = Send("x", PriInfo, map[string]string{"_PID": "1"}); err == nil {
+		t.Errorf("Expected error for trusted field")
+	}
+	if err = Send("x", PriInfo, map[string]string{"MESSAGE": "y"}); err == nil {
+		t.Errorf("Expected error for MESSAGE field")
+	}
+	if err = Send("x", PriDebug+1, nil); err == nil {
+		t.Errorf("Expected error for invalid priority")
+	}
+}
+
+func TestSendLarge(t *testing.T) {
+	c := fakeJournal(t)
+
+	// Larger than the maximum datagram size, so it is passed as a file.
+	msg := strings.Repeat("x", 4<<20)
+	if err := Send(msg, PriInfo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEntry(t, c); got != "MESSAGE="+msg+"\nPRIORITY=6\n" {
+		t.Errorf("Got entry of %d bytes, expected %d", len(got), len(msg)+21)
+	}
+}
+
+func TestMemfd(t *testing.T) {
+	f, err := memfd([]byte("foo"))
+	if err != nil {
+		t.Skipf("memfd not available: %v", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write([]byte("bar")); err == nil {
+		t.Errorf("Writing to sealed memfd succeeded")
+	}
+}
+
+func TestNotEnabled(t *testing.T) {
+	old := socketPath
+	socketPath = filepath.Join(t.TempDir(), "nonexistent")
+	defer func() { socketPath = old }()
+
+	if Enabled() {
+		t.Errorf("Enabled() = true for nonexistent socket")
+	}
+	if err := Send("x", PriInfo, nil); err == nil {
+		t.Errorf("Expected error for nonexistent socket")
+	}
+	if conn != nil {
+		t.Errorf("Socket kept after failed send")
+	}
+}
@@ -0,0 +1,62 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// memfdCreate maps GOARCH to the number of the memfd_create syscall, which is
+// not part of package syscall.
+var memfdCreate = map[string]uintptr{
+	"amd64":   319,
+	"arm64":   279,
+	"386":     356,
+	"arm":     385,
+	"riscv64": 279,
+	"ppc64le": 360,
+	"s390x":   350,
+}
+
+// Flags of memfd_create and the seals of fcntl, which are not part of
+// package syscall.
+const (
+	mfdCloexec      = 0x1
+	mfdAllowSealing = 0x2
+
+	fAddSeals   = 1033
+	fSealSeal   = 0x1
+	fSealShrink = 0x2
+	fSealGrow   = 0x4
+	fSealWrite  = 0x8
+)
+
+// memfd returns a sealed memfd containing b.
+func memfd(b []byte) (*os.File, error) {
+	nr, ok := memfdCreate[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("memfd_create not supported on %s", runtime.GOARCH)
+	}
+
+	name := []byte("journal\x00")
+	fd, _, errno := syscall.Syscall(nr, uintptr(unsafe.Pointer(&name[0])), mfdCloexec|mfdAllowSealing, 0)
+	runtime.KeepAlive(name)
+	if errno != 0 {
+		return nil, errno
+	}
+	f := os.NewFile(fd, "journal")
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	_, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, fAddSeals, fSealSeal|fSealShrink|fSealGrow|fSealWrite)
+	if errno != 0 {
+		f.Close()
+		return nil, errno
+	}
+	return f, nil
+}
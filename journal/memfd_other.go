@@ -0,0 +1,14 @@
+//go:build !linux
+
+package journal
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// memfd returns an error, as memfds are only supported on Linux.
+func memfd(b []byte) (*os.File, error) {
+	return nil, fmt.Errorf("memfd_create not supported on %s", runtime.GOOS)
+}
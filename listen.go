@@ -0,0 +1,47 @@
+package systemd
+
+import (
+	"net"
+)
+
+// PassedListener returns the listener passed by the system manager under the given
+// name (see PassedFileNames). If there is none, e.g. because the program is
+// not run by systemd, a new listener is created with net.Listen(network,
+// address). This way the same binary can be used with and without socket
+// activation:
+//
+//		l, err := systemd.PassedListener("http", "tcp", ":8080")
+func PassedListener(name, network, address string) (net.Listener, error) {
+	l, _, err := listen(name, network, address)
+	return l, err
+}
+
+// listen works like PassedListener, but also returns whether the listener was passed
+// by the system manager.
+func listen(name, network, address string) (l net.Listener, passed bool, err error) {
+	n, err := GetPassedFilesByName(name, &l)
+	if err != nil {
+		return nil, false, err
+	}
+	if n > 0 {
+		return l, true, nil
+	}
+
+	l, err = net.Listen(network, address)
+	return l, false, err
+}
+
+// PassedPacketConn works like PassedListener, but for datagram sockets (e.g.
+// from ListenDatagram=), using net.ListenPacket as a fallback.
+func PassedPacketConn(name, network, address string) (net.PacketConn, error) {
+	var c net.PacketConn
+	n, err := GetPassedFilesByName(name, &c)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return c, nil
+	}
+
+	return net.ListenPacket(network, address)
+}
@@ -0,0 +1,33 @@
+package systemd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPassedListenerFallback(t *testing.T) {
+	osm = &osPackage{}
+	os.Unsetenv("LISTEN_PID")
+
+	l, err := PassedListener("http", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Addr().Network() != "tcp" {
+		t.Errorf("Got network %q, expected tcp", l.Addr().Network())
+	}
+
+	c, err := PassedPacketConn("dns", "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.LocalAddr().Network() != "udp" {
+		t.Errorf("Got network %q, expected udp", c.LocalAddr().Network())
+	}
+
+	if _, err = PassedListener("http", "nonsense", ""); err == nil {
+		t.Errorf("Expected error for invalid network")
+	}
+}
@@ -0,0 +1,276 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Field is a single KEY=VALUE assignment of a notification.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Message is a notification in the wire format of the notification protocol:
+// a datagram of newline-separated KEY=VALUE assignments, together with files
+// and credentials passed as ancillary data. It can be used by supervisors,
+// proxies and tests, that need to receive notifications (see ParseMessage) or
+// send them with full control (see Notifier.Send).
+type Message struct {
+	// Fields are the assignments of the message, in order.
+	Fields []Field
+
+	// Files are passed as SCM_RIGHTS, e.g. with FDSTORE=1 or BARRIER=1.
+	Files []*os.File
+
+	// Credentials are passed as SCM_CREDENTIALS, if not nil. Sending
+	// credentials of another process requires privileges, see PidNotify.
+	// Credentials are only supported on Linux.
+	Credentials *Credentials
+}
+
+// Credentials identify the process sending a message.
+type Credentials struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+// Get returns the value of the field key.
+func (m *Message) Get(key string) (value string, ok bool) {
+	for _, f := range m.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// ParseMessage parses a notification datagram b together with the ancillary
+// data oob, as returned by (*net.UnixConn).ReadMsgUnix, and validates it like
+// Validate. If the ancillary data could be parsed, the message is returned
+// even if err is not nil, so the received files can be closed.
+func ParseMessage(b, oob []byte) (*Message, error) {
+	m := new(Message)
+
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cmsgs {
+		c := &cmsgs[i]
+		if c.Header.Level != syscall.SOL_SOCKET {
+			continue
+		}
+		switch c.Header.Type {
+		case syscall.SCM_RIGHTS:
+			fds, err := syscall.ParseUnixRights(c)
+			if err != nil {
+				return m, err
+			}
+			for _, fd := range fds {
+				m.Files = append(m.Files, osm.NewFile(uintptr(fd), ""))
+			}
+		default:
+			cred, ok, err := parseCredentials(c)
+			if err != nil {
+				return m, err
+			}
+			if ok {
+				m.Credentials = cred
+			}
+		}
+	}
+
+	if m.Fields, err = parseFields(string(b)); err != nil {
+		return m, err
+	}
+	return m, m.Validate()
+}
+
+// parseFields splits state into its KEY=VALUE assignments.
+func parseFields(state string) ([]Field, error) {
+	var fields []Field
+	for _, l := range strings.Split(state, "\n") {
+		// A trailing newline is commonly sent and accepted by systemd.
+		if l == "" {
+			continue
+		}
+		i := strings.IndexByte(l, '=')
+		if i < 0 {
+			return fields, fmt.Errorf("Line %q is not an assignment", l)
+		}
+		fields = append(fields, Field{l[:i], l[i+1:]})
+	}
+	return fields, nil
+}
+
+// newMessage returns the message passing state together with files. It is
+// used to validate the messages sent by the Notify* functions.
+func newMessage(state string, files []*os.File) (*Message, error) {
+	fields, err := parseFields(state)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Fields: fields, Files: files}, nil
+}
+
+// checkKey checks whether key is a valid field name, i.e. consists of
+// uppercase letters, digits and underscores and does not start with a digit.
+func checkKey(key string) error {
+	if key == "" {
+		return errors.New("Empty field name")
+	}
+	if key[0] >= '0' && key[0] <= '9' {
+		return fmt.Errorf("Field name %q starts with a digit", key)
+	}
+	for _, c := range key {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return fmt.Errorf("Invalid character %q in field name %q", c, key)
+		}
+	}
+	return nil
+}
+
+// checkValues returns a function checking that a value is one of vals.
+func checkValues(vals ...string) func(string) error {
+	return func(v string) error {
+		for _, w := range vals {
+			if v == w {
+				return nil
+			}
+		}
+		return fmt.Errorf("Value %q is not one of %q", v, vals)
+	}
+}
+
+// checkUint checks whether v is a decimal unsigned integer.
+func checkUint(v string) error {
+	_, err := strconv.ParseUint(v, 10, 64)
+	return err
+}
+
+// checkPid checks whether v is a positive pid.
+func checkPid(v string) error {
+	pid, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		return err
+	}
+	if pid <= 0 {
+		return fmt.Errorf("Pid %d is not positive", pid)
+	}
+	return nil
+}
+
+// knownFields maps the fields interpreted by systemd to a check of their
+// value. Other fields are passed through, as long as their name is valid.
+var knownFields = map[string]func(string) error{
+	"READY":               checkValues("1"),
+	"RELOADING":           checkValues("1"),
+	"STOPPING":            checkValues("1"),
+	"WATCHDOG":            checkValues("1", "trigger"),
+	"BARRIER":             checkValues("1"),
+	"FDSTORE":             checkValues("1"),
+	"FDSTOREREMOVE":       checkValues("1"),
+	"FDPOLL":              checkValues("0", "1"),
+	"FDNAME":              checkFdName,
+	"ERRNO":               checkUint,
+	"BUSERROR":            checkBusError,
+	"MAINPID":             checkPid,
+	"MONOTONIC_USEC":      checkUint,
+	"EXTEND_TIMEOUT_USEC": checkUint,
+	"WATCHDOG_USEC":       checkUint,
+}
+
+// Validate checks the message for errors, that would make systemd ignore it
+// or parts of it: invalid field names, duplicate fields, invalid values of
+// the fields interpreted by systemd (e.g. READY=2 or MAINPID=0), files passed
+// without FDSTORE=1 or BARRIER=1 and a BARRIER=1 without exactly one file.
+func (m *Message) Validate() error {
+	if len(m.Fields) == 0 {
+		return errors.New("Empty message")
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range m.Fields {
+		if err := checkKey(f.Key); err != nil {
+			return err
+		}
+		if seen[f.Key] {
+			return fmt.Errorf("Duplicate field %s", f.Key)
+		}
+		seen[f.Key] = true
+
+		if strings.IndexByte(f.Value, '\n') >= 0 {
+			return fmt.Errorf("Value of %s contains a newline", f.Key)
+		}
+		if check := knownFields[f.Key]; check != nil {
+			if err := check(f.Value); err != nil {
+				return fmt.Errorf("Invalid %s: %v", f.Key, err)
+			}
+		}
+	}
+
+	switch {
+	case seen["BARRIER"] && len(m.Files) != 1:
+		return fmt.Errorf("BARRIER=1 needs exactly one file, got %d", len(m.Files))
+	case seen["FDSTORE"] && len(m.Files) == 0:
+		return errors.New("FDSTORE=1 without files")
+	case len(m.Files) > 0 && !seen["FDSTORE"] && !seen["BARRIER"]:
+		return errors.New("Files passed without FDSTORE=1 or BARRIER=1")
+	case seen["FDSTOREREMOVE"] && !seen["FDNAME"]:
+		return errors.New("FDSTOREREMOVE=1 without FDNAME")
+	}
+	return nil
+}
+
+// Encode validates the message and returns the datagram and the ancillary
+// data to send it. The files must be kept alive until the message is sent.
+func (m *Message) Encode() (b, oob []byte, err error) {
+	if err = m.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if oob, err = ancillary(m.Credentials, m.Files); err != nil {
+		return nil, nil, err
+	}
+	return []byte(encodeFields(m.Fields)), oob, nil
+}
+
+// encodeFields returns fields in the format of the notification protocol.
+func encodeFields(fields []Field) string {
+	lines := make([]string, len(fields))
+	for i, f := range fields {
+		lines[i] = f.Key + "=" + f.Value
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ancillary returns the control messages to pass cred and files, or nil if
+// there are none.
+func ancillary(cred *Credentials, files []*os.File) ([]byte, error) {
+	var oob []byte
+	if cred != nil {
+		var err error
+		if oob, err = unixCredentials(cred); err != nil {
+			return nil, err
+		}
+	}
+	return append(oob, unixRights(files)...), nil
+}
+
+// Send validates and sends m.
+func (n *Notifier) Send(ctx context.Context, m *Message) error {
+	b, oob, err := m.Encode()
+	if err != nil {
+		return err
+	}
+	err = n.send(ctx, b, oob)
+	runtime.KeepAlive(m.Files)
+	return err
+}
@@ -0,0 +1,21 @@
+package systemd
+
+import "syscall"
+
+// parseCredentials parses an SCM_CREDENTIALS control message. ok is false, if
+// c is of a different type.
+func parseCredentials(c *syscall.SocketControlMessage) (cred *Credentials, ok bool, err error) {
+	if c.Header.Type != syscall.SCM_CREDENTIALS {
+		return nil, false, nil
+	}
+	uc, err := syscall.ParseUnixCredentials(c)
+	if err != nil {
+		return nil, true, err
+	}
+	return &Credentials{Pid: uc.Pid, Uid: uc.Uid, Gid: uc.Gid}, true, nil
+}
+
+// unixCredentials returns the SCM_CREDENTIALS control message for cred.
+func unixCredentials(cred *Credentials) ([]byte, error) {
+	return syscall.UnixCredentials(&syscall.Ucred{Pid: cred.Pid, Uid: cred.Uid, Gid: cred.Gid}), nil
+}
@@ -0,0 +1,79 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	n, err := NewNotifier(conn.LocalAddr().(*net.UnixAddr).Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	cred := &Credentials{Pid: int32(os.Getpid()), Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	sent := &Message{
+		Fields:      []Field{{"FDSTORE", "1"}, {"FDNAME", "pipe"}},
+		Files:       []*os.File{r},
+		Credentials: cred,
+	}
+	if err = n.Send(context.Background(), sent); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	oob := make([]byte, 4096)
+	nb, noob, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseMessage(buf[:nb], oob[:noob])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range got.Files {
+		defer f.Close()
+	}
+
+	if !reflect.DeepEqual(got.Fields, sent.Fields) {
+		t.Errorf("Got fields %q, expected %q", got.Fields, sent.Fields)
+	}
+	if len(got.Files) != 1 {
+		t.Fatalf("Got %d files, expected 1", len(got.Files))
+	}
+	if got.Credentials == nil || *got.Credentials != *cred {
+		t.Errorf("Got credentials %+v, expected %+v", got.Credentials, cred)
+	}
+
+	w.Write([]byte("x"))
+	var b [1]byte
+	if _, err = got.Files[0].Read(b[:]); err != nil || b[0] != 'x' {
+		t.Errorf("Reading passed file: %q, %v", b[:], err)
+	}
+}
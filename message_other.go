@@ -0,0 +1,16 @@
+//go:build !linux
+
+package systemd
+
+import "syscall"
+
+// parseCredentials ignores c, as credentials are only supported on Linux.
+func parseCredentials(c *syscall.SocketControlMessage) (cred *Credentials, ok bool, err error) {
+	return nil, false, nil
+}
+
+// unixCredentials returns an error, as credentials are only supported on
+// Linux.
+func unixCredentials(cred *Credentials) ([]byte, error) {
+	return nil, errUnsupported
+}
@@ -0,0 +1,63 @@
+package systemd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	var testcases = []struct {
+		In     string
+		Fields []Field
+		Err    bool
+	}{
+		{"READY=1", []Field{{"READY", "1"}}, false},
+		{"READY=1\nSTATUS=a=b\n", []Field{{"READY", "1"}, {"STATUS", "a=b"}}, false},
+		{"STATUS=", []Field{{"STATUS", ""}}, false},
+		{"WATCHDOG=trigger", []Field{{"WATCHDOG", "trigger"}}, false},
+		{"X_CUSTOM=foo\nMAINPID=42", []Field{{"X_CUSTOM", "foo"}, {"MAINPID", "42"}}, false},
+		{"", nil, true},
+		{"READY", nil, true},
+		{"READY=2", nil, true},
+		{"ready=1", nil, true},
+		{"=1", nil, true},
+		{"1READY=1", nil, true},
+		{"READY=1\nREADY=1", nil, true},
+		{"MAINPID=0", nil, true},
+		{"MAINPID=foo", nil, true},
+		{"ERRNO=-1", nil, true},
+		{"BUSERROR=foo", nil, true},
+		{"EXTEND_TIMEOUT_USEC=1s", nil, true},
+		{"FDNAME=a:b", nil, true},
+		{"FDSTOREREMOVE=1", nil, true},
+		{"FDSTORE=1\nFDNAME=foo", nil, true},
+		{"BARRIER=1", nil, true},
+	}
+
+	for _, tc := range testcases {
+		m, err := ParseMessage([]byte(tc.In), nil)
+		if tc.Err != (err != nil) {
+			t.Errorf("ParseMessage(%q): unexpected error %v", tc.In, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(m.Fields, tc.Fields) {
+			t.Errorf("ParseMessage(%q) = %q, expected %q", tc.In, m.Fields, tc.Fields)
+		}
+	}
+}
+
+func TestMessageEncode(t *testing.T) {
+	m := &Message{Fields: []Field{{"READY", "1"}, {"STATUS", "up"}}}
+	b, oob, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "READY=1\nSTATUS=up" || oob != nil {
+		t.Errorf("Got %q, %v", b, oob)
+	}
+
+	m = &Message{Fields: []Field{{"STATUS", "a\nb"}}}
+	if _, _, err = m.Encode(); err == nil {
+		t.Errorf("Expected error for newline in value")
+	}
+}
@@ -1,5 +1,9 @@
 package systemd
 
+import (
+	"sync/atomic"
+)
+
 // EnvMask encodes a set of environment-variables that should be cleared by
 // ClearEnv.
 type EnvMask uint32
@@ -10,11 +14,33 @@ const (
 	NotifySocket
 	WatchdogPid
 	WatchdogUsec
+	ListenFdNames
+	RemoteAddr
+	RemotePort
 
-	Listen = ListenPid | ListenFds
+	Listen   = ListenPid | ListenFds | ListenFdNames
 	Watchdog = WatchdogPid | WatchdogUsec
+	Remote   = RemoteAddr | RemotePort
 )
 
+// envVars maps the bits of an EnvMask to the names of the variables.
+var envVars = []struct {
+	mask EnvMask
+	name string
+}{
+	{ListenPid, "LISTEN_PID"},
+	{ListenFds, "LISTEN_FDS"},
+	{NotifySocket, "NOTIFY_SOCKET"},
+	{WatchdogPid, "WATCHDOG_PID"},
+	{WatchdogUsec, "WATCHDOG_USEC"},
+	{ListenFdNames, "LISTEN_FDNAMES"},
+	{RemoteAddr, "REMOTE_ADDR"},
+	{RemotePort, "REMOTE_PORT"},
+}
+
+// consumed is the EnvMask set by ConsumeEnv. It is accessed atomically.
+var consumed uint32
+
 // IsSystemdBootet returns, whether the running system is bootet by systemd.
 func IsSystemdBootet() bool {
 	fi, err := osm.Lstat("/run/systemd/system")
@@ -29,64 +55,33 @@ func IsSystemdBootet() bool {
 // the specified ones intact. It is recommended to call this once after startup
 // is completed.
 func ClearEnv(except EnvMask) {
-	unset := func(str string) {
-		// BUG(aw): Go does not support unsetenv yet. Instead we only set the
-		// variables to the empty string.
-		// see https://code.google.com/p/go/issues/detail?id=6423
-		osm.Setenv(str, "")
-	}
-
-	if except & ListenPid == 0 {
-		unset("LISTEN_PID")
-	}
-	if except & ListenFds == 0 {
-		unset("LISTEN_FDS")
-	}
-	if except & NotifySocket == 0 {
-		unset("NOTIFY_SOCKET")
-	}
-	if except & WatchdogPid == 0 {
-		unset("WATCHDOG_PID")
-	}
-	if except & WatchdogUsec == 0 {
-		unset("WATCHDOG_USEC")
+	for _, v := range envVars {
+		if except&v.mask == 0 {
+			osm.Unsetenv(v.name)
+		}
 	}
 }
 
-// TODO: We really shouldn't have this. Find a way to not need it
-// CheckPath checks of the given file is the same as the given path.
-//func CheckPath(f *os.File, path string) (bool, error) {
-//	s, err := f.Stat()
-//	if err != nil {
-//		return false, err
-//	}
-//
-//	st_fd, ok := s.Sys().(syscall.Stat_t)
-//	if !ok {
-//		return false, errors.New("Unknown stat implementation")
-//	}
-//
-//	var st_path syscall.Stat_t
-//	err = syscall.Stat(path, &st_path)
-//	if err != nil {
-//		if err == syscall.ENOENT || err == syscall.ENOTDIR {
-//			return false, nil
-//		}
+// ConsumeEnv sets the environment variables, that are removed from the
+// environment once they have been read by this package, similar to the
+// unset_environment parameter of sd_listen_fds and sd_notify. This way, they
+// are not inherited by child processes. It returns the previous setting.
 //
-//		return false, err
-//	}
-//
-
+// The variables are removed after reading them in any case, even if they are
+// not meant for this process, e.g. LISTEN_FDS is removed by GetPassedFiles
+// and WATCHDOG_USEC by IsWatchdogActive. NOTIFY_SOCKET is removed, when the
+// first notification is sent; its value is kept, so the Notify* functions
+// can reconnect after an error.
+func ConsumeEnv(mask EnvMask) EnvMask {
+	return EnvMask(atomic.SwapUint32(&consumed, uint32(mask)))
+}
 
-//	switch {
-//	case st_fd.Mode & syscall.S_IFREG != 0 && st_path.Mode & syscall.S_IFREG != 0:
-//		fallthrough
-//	case st_fd.Mode & syscall.S_IFIFO != 0 && st_path.Mode & syscall.S_IFIFO != 0:
-//		return st_fd.Dev == st_path.Dev && st_fd.Ino == st_path.Ino, nil
-//	case st_fd.Mode & syscall.S_IFCHR != 0 && st_path.Mode & syscall.S_IFCHR != 0:
-//		return st_fd.Rdev == st_path.Rdev, nil
-//	default:
-//		return false, errors.New("Unknown type of file")
-//	}
-//
-//}
+// consumeEnv removes the variables in mask from the environment, if they are
+// set to be consumed by ConsumeEnv.
+func consumeEnv(mask EnvMask) {
+	mask &= EnvMask(atomic.LoadUint32(&consumed))
+	if mask == 0 {
+		return
+	}
+	ClearEnv(^mask)
+}
@@ -2,6 +2,7 @@ package systemd
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"testing"
 )
@@ -48,49 +49,48 @@ func TestClearEnv(t *testing.T) {
 	// We don't use a mock here. We just screw with the environment
 	osm = &osPackage{}
 
-	var testcases = []struct{
-		mask EnvMask
-		env  [5]string
-	}{
-		{ 0, [5]string{ "", "", "", "", "" } },
-		{ ListenPid, [5]string{ "T", "", "", "", "" } },
-		{ ListenFds, [5]string{ "", "T", "", "", "" } },
-		{ NotifySocket, [5]string{ "", "", "T", "", "" } },
-		{ WatchdogPid, [5]string{ "", "", "", "T", "" } },
-		{ WatchdogUsec, [5]string{ "", "", "", "", "T" } },
-	}
-
-	setupEnv := func() {
-		os.Setenv("LISTEN_PID", "T")
-		os.Setenv("LISTEN_FDS", "T")
-		os.Setenv("NOTIFY_SOCKET", "T")
-		os.Setenv("WATCHDOG_PID", "T")
-		os.Setenv("WATCHDOG_USEC", "T")
-	}
-	testEnv := func(env [5]string) bool {
-		if os.Getenv("LISTEN_PID") != env[0] {
-			return false
-		}
-		if os.Getenv("LISTEN_FDS") != env[1] {
-			return false
-		}
-		if os.Getenv("NOTIFY_SOCKET") != env[2] {
-			return false
+	for _, tc := range []EnvMask{0, ListenPid, ListenFds, NotifySocket, WatchdogPid, WatchdogUsec, ListenFdNames, RemoteAddr, RemotePort, Listen, Watchdog, Remote} {
+		for _, v := range envVars {
+			os.Setenv(v.name, "T")
 		}
-		if os.Getenv("WATCHDOG_PID") != env[3] {
-			return false
-		}
-		if os.Getenv("WATCHDOG_USEC") != env[4] {
-			return false
+
+		ClearEnv(tc)
+
+		for _, v := range envVars {
+			val, ok := os.LookupEnv(v.name)
+			if tc&v.mask != 0 && val != "T" {
+				t.Errorf("ClearEnv(%#x) changed %s to %q", tc, v.name, val)
+			}
+			if tc&v.mask == 0 && ok {
+				t.Errorf("ClearEnv(%#x) did not unset %s", tc, v.name)
+			}
 		}
-		return true
 	}
+}
 
-	for _, tc := range testcases {
-		setupEnv()
-		ClearEnv(tc.mask)
-		if !testEnv(tc.env) {
-			t.Fail()
+func TestConsumeEnv(t *testing.T) {
+	// We don't use a mock here. We just screw with the environment
+	osm = &osPackage{}
+
+	if old := ConsumeEnv(Watchdog | Remote); old != 0 {
+		t.Errorf("ConsumeEnv returned %#x, expected 0", old)
+	}
+	defer ConsumeEnv(0)
+
+	os.Setenv("WATCHDOG_PID", fmt.Sprintf("%d", os.Getpid()+23))
+	os.Setenv("WATCHDOG_USEC", "1000")
+	os.Setenv("LISTEN_PID", "T")
+
+	if active, _, err := IsWatchdogActive(); active || err != nil {
+		t.Errorf("IsWatchdogActive() = %v, %v", active, err)
+	}
+	for _, v := range []string{"WATCHDOG_PID", "WATCHDOG_USEC"} {
+		if _, ok := os.LookupEnv(v); ok {
+			t.Errorf("%s was not consumed", v)
 		}
 	}
+	if os.Getenv("LISTEN_PID") != "T" {
+		t.Errorf("LISTEN_PID was consumed")
+	}
+	os.Unsetenv("LISTEN_PID")
 }
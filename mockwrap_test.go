@@ -123,8 +123,40 @@ func (m *mock) NewFile(fd uintptr, name string) *os.File {
 	return c.Return[0].(*os.File)
 }
 
-func (m *mock) Setenv(key, val string) error {
-	panic("Not implemented Setenv")
+func (m *mock) Unsetenv(key string) error {
+	c := m.getCall()
+
+	if key != c.Args[0].(string) {
+		panic(argError{"Unsetenv", c.Args[0], key})
+	}
+
+	return nil
+}
+
+func (m *mock) Close(fd int) error {
+	c := m.getCall()
+
+	if c.Args[0].(int) != fd {
+		panic(argError{"Close", c.Args[0], fd})
+	}
+
+	if n, ok := c.Return[0].(syscall.Errno); ok && n != 0 {
+		return n
+	}
+	return nil
+}
+
+func (m *mock) Dup(fd int) (int, error) {
+	c := m.getCall()
+
+	if c.Args[0].(int) != fd {
+		panic(argError{"Dup", c.Args[0], fd})
+	}
+
+	if n, ok := c.Return[1].(syscall.Errno); ok && n != 0 {
+		return -1, n
+	}
+	return c.Return[0].(int), nil
 }
 
 func (m *mock) Fstat(fd int, st *syscall.Stat_t) error {
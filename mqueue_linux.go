@@ -0,0 +1,90 @@
+package systemd
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// MessageQueue is a POSIX message queue, as passed by ListenMessageQueue= in
+// a socket unit.
+type MessageQueue struct {
+	*os.File
+}
+
+// Send adds msg to the queue with the given priority. It blocks, if the queue
+// is full.
+func (q *MessageQueue) Send(msg []byte, prio uint) error {
+	var p unsafe.Pointer
+	if len(msg) > 0 {
+		p = unsafe.Pointer(&msg[0])
+	}
+
+	rc, err := q.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_MQ_TIMEDSEND, fd, uintptr(p), uintptr(len(msg)), uintptr(prio), 0, 0)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Receive removes the oldest message with the highest priority from the queue
+// and stores it in b, which must be at least as large as the maximum message
+// size of the queue. It blocks, if the queue is empty.
+func (q *MessageQueue) Receive(b []byte) (n int, prio uint, err error) {
+	var p unsafe.Pointer
+	if len(b) > 0 {
+		p = unsafe.Pointer(&b[0])
+	}
+
+	rc, err := q.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The kernel stores the priority as a C unsigned int.
+	var r uintptr
+	var p32 uint32
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		r, _, errno = syscall.Syscall6(syscall.SYS_MQ_TIMEDRECEIVE, fd, uintptr(p), uintptr(len(b)), uintptr(unsafe.Pointer(&p32)), 0, 0)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(r), uint(p32), nil
+}
+
+// getMessageQueue checks if the file descriptor at the given offset is a POSIX
+// message queue and returns a MessageQueue bound to this file descriptor.
+func getMessageQueue(num int) (q *MessageQueue, err error) {
+	fd := listenFdsStart + num
+
+	ok, err := IsMQ(fd, "")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("Not a message queue")
+	}
+
+	err = adopt(num, "", func(f *os.File) error {
+		q = &MessageQueue{f}
+		return nil
+	})
+	return q, err
+}
@@ -0,0 +1,56 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestMessageQueue(t *testing.T) {
+	osm = &osPackage{}
+
+	name := []byte(fmt.Sprintf("systemd-test-%d\x00", os.Getpid()))
+	fd, _, errno := syscall.Syscall6(syscall.SYS_MQ_OPEN, uintptr(unsafe.Pointer(&name[0])), syscall.O_RDWR|syscall.O_CREAT|syscall.O_EXCL, 0600, 0, 0, 0)
+	if errno != 0 {
+		t.Skipf("Can not create message queue: %v", errno)
+	}
+	defer syscall.Syscall(syscall.SYS_MQ_UNLINK, uintptr(unsafe.Pointer(&name[0])), 0, 0)
+
+	var q *MessageQueue
+	if err := storeFd(int(fd)-listenFdsStart, reflect.ValueOf(&q).Elem()); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err := q.Send([]byte("foo"), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 8192)
+	n, prio, err := q.Receive(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "foo" || prio != 3 {
+		t.Errorf("Got %q with priority %d", b[:n], prio)
+	}
+}
+
+func TestMessageQueueWrongType(t *testing.T) {
+	osm = &osPackage{}
+
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+
+	var q *MessageQueue
+	if err = storeFile(t, rawFd(t, uc), &q); err == nil {
+		t.Errorf("Expected error when storing UDP socket in *MessageQueue")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package systemd
+
+import (
+	"os"
+)
+
+// MessageQueue is a POSIX message queue, as passed by ListenMessageQueue= in
+// a socket unit. Message queues are only supported on Linux.
+type MessageQueue struct {
+	*os.File
+}
+
+// Send adds msg to the queue with the given priority.
+func (q *MessageQueue) Send(msg []byte, prio uint) error {
+	return errUnsupported
+}
+
+// Receive removes the oldest message with the highest priority from the
+// queue and stores it in b.
+func (q *MessageQueue) Receive(b []byte) (n int, prio uint, err error) {
+	return 0, 0, errUnsupported
+}
+
+// getMessageQueue returns an error, as message queues are only supported on
+// Linux.
+func getMessageQueue(num int) (*MessageQueue, error) {
+	return nil, errUnsupported
+}
@@ -0,0 +1,83 @@
+package systemd
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// NetlinkConn is a netlink socket, as passed by ListenNetlink= in a socket
+// unit. The net package does not support netlink, so it gives access to the
+// underlying file; messages can be read and written with Read and Write.
+type NetlinkConn struct {
+	*os.File
+}
+
+// Protocol returns the netlink protocol of the socket, e.g.
+// syscall.NETLINK_KOBJECT_UEVENT.
+func (c *NetlinkConn) Protocol() (int, error) {
+	return sockoptInt(c.File, syscall.SO_PROTOCOL)
+}
+
+// ReadFromNetlink reads one message into b and returns its length and the
+// address of the sender.
+func (c *NetlinkConn) ReadFromNetlink(b []byte) (n int, from *syscall.SockaddrNetlink, err error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var sa syscall.Sockaddr
+	var rerr error
+	err = rc.Read(func(fd uintptr) bool {
+		n, sa, rerr = syscall.Recvfrom(int(fd), b, 0)
+		return rerr != syscall.EAGAIN
+	})
+	if err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	from, _ = sa.(*syscall.SockaddrNetlink)
+	return n, from, nil
+}
+
+// sockoptInt reads the integer socket option opt at SOL_SOCKET level of f.
+func sockoptInt(f *os.File, opt int) (int, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var v int
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v, serr
+}
+
+// getNetlinkConn checks if the file descriptor at the given offset is a
+// netlink socket and returns a NetlinkConn bound to this file descriptor.
+func getNetlinkConn(num int) (conn *NetlinkConn, err error) {
+	fd := listenFdsStart + num
+
+	ok, err := IsSocket(fd, syscall.AF_NETLINK, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("Not a netlink socket")
+	}
+
+	err = adopt(num, "", func(f *os.File) error {
+		conn = &NetlinkConn{f}
+		return nil
+	})
+	return conn, err
+}
@@ -0,0 +1,47 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// storeFile places the file descriptor of f in target, like a passed file
+// descriptor. f is consumed.
+func storeFile(t *testing.T, f *os.File, target interface{}) error {
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storeFd(fd-listenFdsStart, reflect.ValueOf(target).Elem())
+}
+
+func TestNetlinkConn(t *testing.T) {
+	osm = &osPackage{}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nl *NetlinkConn
+	if err = storeFile(t, os.NewFile(uintptr(fd), ""), &nl); err != nil {
+		t.Errorf("Storing netlink socket in *NetlinkConn: %v", err)
+	} else {
+		if p, err := nl.Protocol(); err != nil || p != syscall.NETLINK_ROUTE {
+			t.Errorf("Got protocol %d, %v, expected %d", p, err, syscall.NETLINK_ROUTE)
+		}
+		nl.Close()
+	}
+
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	if err = storeFile(t, rawFd(t, uc), &nl); err == nil {
+		t.Errorf("Expected error when storing UDP socket in *NetlinkConn")
+	}
+}
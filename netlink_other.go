@@ -0,0 +1,23 @@
+//go:build !linux
+
+package systemd
+
+import (
+	"os"
+)
+
+// NetlinkConn is a netlink socket, as passed by ListenNetlink= in a socket
+// unit. Netlink is only supported on Linux.
+type NetlinkConn struct {
+	*os.File
+}
+
+// Protocol returns the netlink protocol of the socket.
+func (c *NetlinkConn) Protocol() (int, error) {
+	return 0, errUnsupported
+}
+
+// getNetlinkConn returns an error, as netlink is only supported on Linux.
+func getNetlinkConn(num int) (*NetlinkConn, error) {
+	return nil, errUnsupported
+}
@@ -0,0 +1,200 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification is a message to the system manager, that consists of several
+// fields. It is sent in a single datagram, so all fields are applied
+// atomically. The methods add a field and return the Notification, so they
+// can be chained:
+//
+//		err := systemd.NewNotification().Ready().Status("Listening on :80").Send()
+//
+// Setting a field a second time replaces its value. Invalid values are
+// reported by Err and Send.
+type Notification struct {
+	fields []Field
+	err    error
+}
+
+// NewNotification returns an empty Notification.
+func NewNotification() *Notification {
+	return &Notification{}
+}
+
+// set adds the field key=value, or records err, if it is not nil.
+func (n *Notification) set(key, value string, err error) *Notification {
+	if err != nil {
+		if n.err == nil {
+			n.err = fmt.Errorf("Invalid %s: %v", key, err)
+		}
+		return n
+	}
+	if strings.ContainsAny(value, "\n\r") {
+		return n.set(key, "", errors.New("Value may not contain newlines"))
+	}
+
+	for i := range n.fields {
+		if n.fields[i].Key == key {
+			n.fields[i].Value = value
+			return n
+		}
+	}
+	n.fields = append(n.fields, Field{key, value})
+	return n
+}
+
+// Ready tells the system manager that daemon startup or reloading is
+// finished (READY=1).
+func (n *Notification) Ready() *Notification {
+	return n.set("READY", "1", nil)
+}
+
+// Reloading tells the system manager that the daemon is reloading its
+// configuration (RELOADING=1). It also adds the current time as
+// MONOTONIC_USEC=, which is needed for Type=notify-reload.
+func (n *Notification) Reloading() *Notification {
+	now, err := monotonicNow()
+	n.set("RELOADING", "1", nil)
+	return n.set("MONOTONIC_USEC", usec(now), err)
+}
+
+// Stopping tells the system manager that the daemon is shutting down
+// (STOPPING=1).
+func (n *Notification) Stopping() *Notification {
+	return n.set("STOPPING", "1", nil)
+}
+
+// Status sets a single-line status string that describes the daemon state.
+func (n *Notification) Status(status string) *Notification {
+	return n.set("STATUS", status, nil)
+}
+
+// Errno sets an errno-style error code, describing why the daemon failed.
+func (n *Notification) Errno(errno uint) *Notification {
+	return n.set("ERRNO", strconv.FormatUint(uint64(errno), 10), nil)
+}
+
+// BusError sets a D-Bus style error name (e.g.
+// "org.freedesktop.DBus.Error.TimedOut"), describing why the daemon failed.
+func (n *Notification) BusError(name string) *Notification {
+	return n.set("BUSERROR", name, checkBusError(name))
+}
+
+// checkBusError checks whether name is a valid D-Bus error name.
+func checkBusError(name string) error {
+	if len(name) > 255 {
+		return errors.New("Name too long")
+	}
+
+	elems := strings.Split(name, ".")
+	if len(elems) < 2 {
+		return errors.New("Name needs at least two elements")
+	}
+	for _, e := range elems {
+		if e == "" || (e[0] >= '0' && e[0] <= '9') {
+			return fmt.Errorf("Invalid element %q", e)
+		}
+		for _, c := range e {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+				return fmt.Errorf("Invalid character %q", c)
+			}
+		}
+	}
+	return nil
+}
+
+// MainPid sets the main pid of the daemon, in case this process isn't it.
+func (n *Notification) MainPid(pid int) *Notification {
+	var err error
+	if pid <= 0 {
+		err = fmt.Errorf("Pid %d is not positive", pid)
+	}
+	return n.set("MAINPID", strconv.Itoa(pid), err)
+}
+
+// Watchdog adds a keep-alive ping (WATCHDOG=1).
+func (n *Notification) Watchdog() *Notification {
+	return n.set("WATCHDOG", "1", nil)
+}
+
+// WatchdogTrigger tells the system manager to act as if the watchdog timeout
+// passed (WATCHDOG=trigger). It replaces a keep-alive ping added with
+// Watchdog.
+func (n *Notification) WatchdogTrigger() *Notification {
+	return n.set("WATCHDOG", "trigger", nil)
+}
+
+// WatchdogUsec changes the watchdog timeout of the service to d
+// (WATCHDOG_USEC=).
+func (n *Notification) WatchdogUsec(d time.Duration) *Notification {
+	var err error
+	if d < time.Microsecond {
+		err = fmt.Errorf("Duration %v is less than a microsecond", d)
+	}
+	return n.set("WATCHDOG_USEC", usec(d), err)
+}
+
+// ExtendTimeout asks the system manager to extend the current startup,
+// runtime or shutdown timeout to d from now (EXTEND_TIMEOUT_USEC=).
+func (n *Notification) ExtendTimeout(d time.Duration) *Notification {
+	var err error
+	if d < time.Microsecond {
+		err = fmt.Errorf("Duration %v is less than a microsecond", d)
+	}
+	return n.set("EXTEND_TIMEOUT_USEC", usec(d), err)
+}
+
+// usec formats d as a number of microseconds.
+func usec(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Microsecond), 10)
+}
+
+// Set sets a custom field. To not clash with fields of future versions of
+// systemd, key must start with "X_" and only consist of uppercase letters,
+// digits and underscores.
+func (n *Notification) Set(key, value string) *Notification {
+	return n.set(key, value, checkCustomKey(key))
+}
+
+// checkCustomKey checks whether key is a valid name for a custom field.
+func checkCustomKey(key string) error {
+	if !strings.HasPrefix(key, "X_") || len(key) == 2 {
+		return errors.New("Custom fields must start with X_")
+	}
+	for _, c := range key {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return fmt.Errorf("Invalid character %q", c)
+		}
+	}
+	return nil
+}
+
+// Err returns the first error that occured when adding a field.
+func (n *Notification) Err() error {
+	return n.err
+}
+
+// String returns the notification in the format of the notification protocol.
+func (n *Notification) String() string {
+	return encodeFields(n.fields)
+}
+
+// Send sends the notification to the system manager.
+func (n *Notification) Send() error {
+	return n.SendTo(context.Background(), defaultNotifier)
+}
+
+// SendTo sends the notification using the given Notifier.
+func (n *Notification) SendTo(ctx context.Context, nf *Notifier) error {
+	if n.err != nil {
+		return n.err
+	}
+	return nf.Send(ctx, &Message{Fields: n.fields})
+}
@@ -0,0 +1,69 @@
+package systemd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotification(t *testing.T) {
+	var testcases = []struct {
+		N   *Notification
+		Out string
+		Err bool
+	}{
+		{NewNotification().Ready(), "READY=1", false},
+		{NewNotification().Ready().Status("Listening"), "READY=1\nSTATUS=Listening", false},
+		{NewNotification().Status("foo").Status("bar"), "STATUS=bar", false},
+		{NewNotification().Stopping().Watchdog(), "STOPPING=1\nWATCHDOG=1", false},
+		{NewNotification().Errno(2).BusError("org.freedesktop.DBus.Error.TimedOut"), "ERRNO=2\nBUSERROR=org.freedesktop.DBus.Error.TimedOut", false},
+		{NewNotification().MainPid(42), "MAINPID=42", false},
+		{NewNotification().ExtendTimeout(3 * time.Second), "EXTEND_TIMEOUT_USEC=3000000", false},
+		{NewNotification().Set("X_FOO", "bar baz"), "X_FOO=bar baz", false},
+		{NewNotification().Watchdog().WatchdogTrigger(), "WATCHDOG=trigger", false},
+		{NewNotification().WatchdogUsec(20 * time.Second), "WATCHDOG_USEC=20000000", false},
+		{NewNotification().WatchdogUsec(0), "", true},
+		{NewNotification().Status("foo\nbar"), "", true},
+		{NewNotification().BusError("nodots"), "", true},
+		{NewNotification().BusError("org.1foo"), "", true},
+		{NewNotification().BusError("org.foo-bar"), "", true},
+		{NewNotification().BusError("org." + strings.Repeat("x", 255)), "", true},
+		{NewNotification().MainPid(0), "", true},
+		{NewNotification().ExtendTimeout(0), "", true},
+		{NewNotification().Set("FOO", "bar"), "", true},
+		{NewNotification().Set("X_", "bar"), "", true},
+		{NewNotification().Set("X_foo", "bar"), "", true},
+		{NewNotification().Set("X_FOO", "bar\n"), "", true},
+		{NewNotification().Ready().Set("X=", "").Status("foo"), "", true},
+	}
+
+	for _, tc := range testcases {
+		err := tc.N.Err()
+		if tc.Err != (err != nil) {
+			t.Errorf("Notification %q: unexpected error %v", tc.N.String(), err)
+			continue
+		}
+		if err == nil && tc.N.String() != tc.Out {
+			t.Errorf("Got %q, expected %q", tc.N.String(), tc.Out)
+		}
+	}
+}
+
+func TestNotificationSend(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	if err := NewNotification().Ready().Status("up").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "READY=1\nSTATUS=up" {
+		t.Errorf("Got %q", msg)
+	}
+
+	if err := NewNotification().Send(); err == nil {
+		t.Errorf("Expected error for empty notification")
+	}
+	if err := NewNotification().MainPid(-1).Send(); err == nil {
+		t.Errorf("Expected error for invalid notification")
+	}
+}
@@ -0,0 +1,210 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrNotifierClosed is returned when sending on a closed Notifier.
+var ErrNotifierClosed = errors.New("Notifier is closed")
+
+// Notifier sends notifications to the system manager. The package-level
+// Notify* functions use a default Notifier, that reads the address from
+// NOTIFY_SOCKET; creating an own one is useful to set timeouts, to talk to a
+// different socket (e.g. in tests) or to not share the connection with other
+// users of this package. A Notifier is safe for concurrent use.
+type Notifier struct {
+	// WriteTimeout limits the time sending a single notification may take.
+	// If it is zero, only the deadline of the passed context applies.
+	WriteTimeout time.Duration
+
+	// Retries is the number of times sending a notification is retried
+	// with a new connection, if it fails. Independent of this, a new
+	// connection is made for the next notification after any error.
+	Retries int
+
+	addr   string
+	mtx    sync.Mutex
+	conn   net.Conn
+	single bool
+	closed bool
+}
+
+// defaultNotifier is used by the package-level Notify* functions.
+var defaultNotifier = NotifierFromEnv()
+
+// NewNotifier returns a Notifier, that sends notifications to the socket at
+// addr, which must be an absolute path, an abstract socket starting with '@'
+// or a vsock address (see DialNotify). The connection is made when the first
+// notification is sent.
+func NewNotifier(addr string) (*Notifier, error) {
+	if err := checkNotifyAddr(addr); err != nil {
+		return nil, err
+	}
+	return &Notifier{addr: addr}, nil
+}
+
+// NotifierFromEnv returns a Notifier, that sends notifications to the socket
+// given in NOTIFY_SOCKET. The variable is read when connecting.
+func NotifierFromEnv() *Notifier {
+	return &Notifier{}
+}
+
+// ResetDefaultNotifier drops the connection of the default Notifier used by
+// the package-level Notify* functions, so that the next notification reads
+// NOTIFY_SOCKET again. It is meant for tests, that point NOTIFY_SOCKET to a
+// fake notification socket, like package systemdtest does.
+func ResetDefaultNotifier() {
+	defaultNotifier.mtx.Lock()
+	defer defaultNotifier.mtx.Unlock()
+
+	if defaultNotifier.conn != nil {
+		defaultNotifier.conn.Close()
+		defaultNotifier.conn = nil
+	}
+}
+
+// dial connects to the notification socket.
+func (n *Notifier) dial() (err error) {
+	addr := n.addr
+	if addr == "" {
+		addr, err = envNotifyAddr()
+		if err != nil {
+			return err
+		}
+	}
+
+	n.conn, n.single, err = dialNotify(addr)
+	return err
+}
+
+// send sends state together with the ancillary data in oob.
+func (n *Notifier) send(ctx context.Context, state, oob []byte) (err error) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if n.closed {
+		return ErrNotifierClosed
+	}
+
+	for try := 0; try <= n.Retries; try++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		err = n.trySend(ctx, state, oob)
+		if err == nil || err == ErrNoNotifySocket {
+			return err
+		}
+	}
+	return err
+}
+
+// trySend makes a single attempt at sending a notification. n.mtx must be
+// held.
+func (n *Notifier) trySend(ctx context.Context, state, oob []byte) (err error) {
+	if n.conn == nil {
+		if err = n.dial(); err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		if err != nil || n.single {
+			n.conn.Close()
+			n.conn = nil
+		}
+	}()
+
+	deadline, _ := ctx.Deadline()
+	if n.WriteTimeout > 0 {
+		t := time.Now().Add(n.WriteTimeout)
+		if deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	if err = n.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	if len(oob) == 0 {
+		_, err = n.conn.Write(state)
+		return err
+	}
+
+	sc, ok := n.conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("Can not pass ancillary data over %T", n.conn)
+	}
+	return writeMsg(sc, state, oob)
+}
+
+// Notify sends a message to the system manager, like the package-level
+// Notify.
+func (n *Notifier) Notify(ctx context.Context, state string) error {
+	return n.NotifyWithFiles(ctx, state)
+}
+
+// NotifyWithFiles sends a message to the system manager together with files,
+// like the package-level NotifyWithFiles.
+func (n *Notifier) NotifyWithFiles(ctx context.Context, state string, files ...*os.File) error {
+	m, err := newMessage(state, files)
+	if err != nil {
+		return err
+	}
+	return n.Send(ctx, m)
+}
+
+// Close closes the connection to the notification socket. Sending further
+// notifications returns ErrNotifierClosed.
+func (n *Notifier) Close() error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	n.closed = true
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
+
+// unixRights returns the SCM_RIGHTS control message for files, or nil if
+// there are none. The files must be kept alive until the message is sent.
+func unixRights(files []*os.File) []byte {
+	if len(files) == 0 {
+		return nil
+	}
+
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	return syscall.UnixRights(fds...)
+}
+
+// writeMsg writes b together with the ancillary data in oob to the connected
+// conn. This is needed, because net refuses WriteMsgUnix on connected
+// datagram sockets.
+func writeMsg(conn syscall.Conn, b, oob []byte) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = rc.Write(func(fd uintptr) bool {
+		serr = syscall.Sendmsg(int(fd), b, oob, nil, 0)
+		return serr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
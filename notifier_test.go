@@ -0,0 +1,84 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// listenUnixgram creates a datagram socket at path, replacing an existing one.
+func listenUnixgram(t *testing.T, path string) *net.UnixConn {
+	os.Remove(path)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestNewNotifier(t *testing.T) {
+	for _, addr := range []string{"", "relative/path", "unix:/foo"} {
+		if _, err := NewNotifier(addr); err == nil {
+			t.Errorf("NewNotifier(%q): expected error", addr)
+		}
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify")
+	conn := listenUnixgram(t, path)
+
+	n, err := NewNotifier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = n.Notify(context.Background(), "READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "READY=1" {
+		t.Errorf("Got %q", msg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = n.Notify(ctx, "READY=1"); err != context.Canceled {
+		t.Errorf("Got %v for cancelled context, expected %v", err, context.Canceled)
+	}
+
+	// Replace the socket, so the connection goes stale
+	conn.Close()
+	conn = listenUnixgram(t, path)
+	defer conn.Close()
+
+	if err = n.Notify(context.Background(), "STATUS=1"); err == nil {
+		t.Errorf("Expected error on stale connection")
+	}
+	if err = n.Notify(context.Background(), "STATUS=2"); err != nil {
+		t.Errorf("Notifier did not reconnect: %v", err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "STATUS=2" {
+		t.Errorf("Got %q", msg)
+	}
+
+	conn.Close()
+	conn = listenUnixgram(t, path)
+	defer conn.Close()
+
+	n.Retries = 1
+	if err = NewNotification().Status("3").SendTo(context.Background(), n); err != nil {
+		t.Errorf("Notifier did not retry: %v", err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "STATUS=3" {
+		t.Errorf("Got %q", msg)
+	}
+
+	if err = n.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = n.Notify(context.Background(), "READY=1"); err != ErrNotifierClosed {
+		t.Errorf("Got %v after Close, expected %v", err, ErrNotifierClosed)
+	}
+}
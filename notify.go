@@ -1,69 +1,132 @@
 package systemd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-var (
-	notifyConn *net.UnixConn
-	notifyMtx  sync.Mutex
-)
+// ErrNoNotifySocket is returned by the Notify* functions, if NOTIFY_SOCKET is
+// not set, e.g. because the process is not run by systemd.
+var ErrNoNotifySocket = errors.New("No notification socket found")
 
 // NotifyConn returns a *net.UnixConn that can be used for special stuff that
 // is not covered by other functions. If you do not have good reasons to need
-// this, you should probably use the Notify* functions.
+// this, you should probably use the Notify* functions. If NOTIFY_SOCKET is a
+// vsock address, an error is returned; use DialNotify instead.
 func NotifyConn() (*net.UnixConn, error) {
-	e := osm.Getenv("NOTIFY_SOCKET")
-	if e == "" {
-		return nil, fmt.Errorf("No notification socket found")
+	conn, err := DialNotify()
+	if err != nil {
+		return nil, err
 	}
-
-	if (e[0] != '@' && e[0] != '/') {
-		return nil, fmt.Errorf("Notification socket must be an abstract socket or an absolute path")
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("Notification socket is not a unix domain socket")
 	}
+	return uc, nil
+}
 
-	addr, err := net.ResolveUnixAddr("unixgram", e)
+// DialNotify works like NotifyConn, but also supports vsock addresses
+// (vsock:CID:PORT, vsock-dgram:, vsock-stream: and vsock-seqpacket:, as used
+// e.g. for virtual machines). For those, the returned connection is an
+// AF_VSOCK socket, that supports Write, Close, deadlines and SyscallConn. For
+// vsock-stream and vsock-seqpacket, every notification needs a connection of
+// its own.
+func DialNotify() (net.Conn, error) {
+	addr, err := envNotifyAddr()
 	if err != nil {
-		return nil, fmt.Errorf("Could not resolve socket addres: %v", err.Error())
+		return nil, err
 	}
 
-	conn, err := net.DialUnix("unixgram", nil, addr)
-	if err != nil {
-		return nil, fmt.Errorf("Could not connect to notification socket: %v", err.Error())
+	conn, _, err := dialNotify(addr)
+	return conn, err
+}
+
+// consumedNotify remembers NOTIFY_SOCKET, once it was removed from the
+// environment by ConsumeEnv, so that connections can still be reestablished.
+var consumedNotify struct {
+	sync.Mutex
+	addr string
+}
+
+// envNotifyAddr returns the address of the notification socket from the
+// environment.
+func envNotifyAddr() (string, error) {
+	consumedNotify.Lock()
+	defer consumedNotify.Unlock()
+
+	e := osm.Getenv("NOTIFY_SOCKET")
+	if e != "" && EnvMask(atomic.LoadUint32(&consumed))&NotifySocket != 0 {
+		consumedNotify.addr = e
+		consumeEnv(NotifySocket)
 	}
-	return conn, nil
+	if e == "" {
+		e = consumedNotify.addr
+	}
+	if e == "" {
+		return "", ErrNoNotifySocket
+	}
+	return e, nil
 }
 
-// Notify sends a custom message to the system manager (see the manpage of
-// sd_notify for more information). It can be used to implement own extensions
-// to the startup-notification protocol. For everything else it is recommended
-// to use one of the special notification-functions.
-func Notify(state string) (err error) {
-	notifyMtx.Lock()
-	defer notifyMtx.Unlock()
+// checkNotifyAddr checks whether addr is a valid address of a notification
+// socket.
+func checkNotifyAddr(addr string) error {
+	if _, _, ok, err := parseVsockAddr(addr); ok {
+		return err
+	}
+	if addr == "" || (addr[0] != '@' && addr[0] != '/') {
+		return fmt.Errorf("Notification socket must be an abstract socket, an absolute path or a vsock address")
+	}
+	return nil
+}
 
-	if notifyConn == nil {
-		notifyConn, err = NotifyConn()
-		if err != nil {
-			return err
-		}
+// dialNotify connects to the notification socket at addr. single is true, if
+// the connection can only be used for a single notification.
+func dialNotify(addr string) (conn net.Conn, single bool, err error) {
+	if err = checkNotifyAddr(addr); err != nil {
+		return nil, false, err
 	}
 
-	defer func() {
+	if va, sotype, ok, _ := parseVsockAddr(addr); ok {
+		vc, err := dialVsock(va, sotype)
+		if err != nil {
+			return nil, false, fmt.Errorf("Could not connect to notification socket: %v", err.Error())
+		}
+		t, err := syscall.GetsockoptInt(int(vc.Fd()), syscall.SOL_SOCKET, syscall.SO_TYPE)
 		if err != nil {
-			notifyConn.Close()
-			notifyConn = nil
+			vc.Close()
+			return nil, false, err
 		}
-	}()
+		return vc, t != syscall.SOCK_DGRAM, nil
+	}
 
-	_, err = notifyConn.Write([]byte(state))
+	ua, err := net.ResolveUnixAddr("unixgram", addr)
 	if err != nil {
-		return err
+		return nil, false, fmt.Errorf("Could not resolve socket addres: %v", err.Error())
 	}
-	return nil
+
+	uc, err := net.DialUnix("unixgram", nil, ua)
+	if err != nil {
+		return nil, false, fmt.Errorf("Could not connect to notification socket: %v", err.Error())
+	}
+	return uc, false, nil
+}
+
+// Notify sends a custom message to the system manager (see the manpage of
+// sd_notify for more information). It can be used to implement own extensions
+// to the startup-notification protocol. For everything else it is recommended
+// to use one of the special notification-functions. Messages, that systemd
+// would ignore, are not sent and an error is returned instead (see
+// Message.Validate).
+func Notify(state string) error {
+	return defaultNotifier.Notify(context.Background(), state)
 }
 
 // NotifyReady tells the init system that daemon startup is finished.
@@ -74,10 +137,7 @@ func NotifyReady() error {
 // NotifyStatus passes a single-line statusu string back to the init system
 // that describes the daemon state.
 func NotifyStatus(status string) error {
-	if strings.ContainsAny(status, "\n\r") {
-		return fmt.Errorf("Status may not contain newlines")
-	}
-	return Notify(fmt.Sprintf("STATUS=%s", status))
+	return NewNotification().Status(status).Send()
 }
 
 // NotifyErrno sends an errno-style error code to the init system.
@@ -94,3 +154,27 @@ func NotifyMainPid(pid int) error {
 func NotifyWatchdog() error {
 	return Notify("WATCHDOG=1")
 }
+
+// NotifyWatchdogTrigger tells the system manager to act as if the watchdog
+// timeout passed, e.g. to restart the service, if it detected a problem
+// itself.
+func NotifyWatchdogTrigger() error {
+	return NewNotification().WatchdogTrigger().Send()
+}
+
+// NotifyWatchdogUsec changes the watchdog timeout of the service to d, e.g.
+// to extend it during a phase of heavy load. WatchdogPingers using the
+// timeout from the environment, like the one started by AutoWatchdog, switch
+// to pinging every d/2 and send a ping immediately.
+func NotifyWatchdogUsec(d time.Duration) error {
+	if err := NewNotification().WatchdogUsec(d).Send(); err != nil {
+		return err
+	}
+
+	envPingers.Lock()
+	defer envPingers.Unlock()
+	for w := range envPingers.m {
+		w.setInterval(d / 2)
+	}
+	return nil
+}
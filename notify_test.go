@@ -0,0 +1,155 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeNotifySocket creates a notification socket and points NOTIFY_SOCKET at
+// it. The environment and the cached connection are reset by the returned
+// function.
+func fakeNotifySocket(t *testing.T) (*net.UnixConn, func()) {
+	osm = &osPackage{}
+
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, hadOld := os.LookupEnv("NOTIFY_SOCKET")
+	os.Setenv("NOTIFY_SOCKET", path)
+	ResetDefaultNotifier()
+
+	return conn, func() {
+		conn.Close()
+		if hadOld {
+			os.Setenv("NOTIFY_SOCKET", old)
+		} else {
+			os.Unsetenv("NOTIFY_SOCKET")
+		}
+		ResetDefaultNotifier()
+	}
+}
+
+// readNotify reads one notification and the files passed along with it.
+func readNotify(t *testing.T, conn *net.UnixConn) (string, []*os.File) {
+	buf := make([]byte, 4096)
+	oob := make([]byte, 4096)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files []*os.File
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range msgs {
+		fds, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), ""))
+		}
+	}
+	return string(buf[:n]), files
+}
+
+func TestNotify(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	if err := NotifyReady(); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "READY=1" {
+		t.Errorf("Got %q, expected READY=1", msg)
+	}
+
+	if err := NotifyStatus("foo\nbar"); err == nil {
+		t.Errorf("Expected error for multi-line status")
+	}
+	if err := Notify("READY=2"); err == nil {
+		t.Errorf("Expected error for invalid READY")
+	}
+	if err := Notify("READY"); err == nil {
+		t.Errorf("Expected error for line without assignment")
+	}
+	if err := NotifyMainPid(0); err == nil {
+		t.Errorf("Expected error for MAINPID=0")
+	}
+
+	if err := NotifyErrno(5); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "ERRNO=5" {
+		t.Errorf("Got %q, expected ERRNO=5", msg)
+	}
+}
+
+func TestNotifyConn(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	uc, err := NotifyConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	if _, err = uc.Write([]byte("READY=1")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "READY=1" {
+		t.Errorf("Got %q, expected READY=1", msg)
+	}
+
+	c, err := DialNotify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := c.(*net.UnixConn); !ok {
+		t.Errorf("DialNotify() returned %T, expected *net.UnixConn", c)
+	}
+}
+
+func TestNotifyConsumeEnv(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	ConsumeEnv(NotifySocket)
+	defer ConsumeEnv(0)
+	defer func() {
+		consumedNotify.Lock()
+		consumedNotify.addr = ""
+		consumedNotify.Unlock()
+	}()
+
+	if err := NotifyReady(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := os.LookupEnv("NOTIFY_SOCKET"); ok {
+		t.Errorf("NOTIFY_SOCKET was not consumed")
+	}
+
+	// Dropping the connection, like after an error, must not lose the
+	// address.
+	ResetDefaultNotifier()
+	if err := NotifyWatchdog(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"READY=1", "WATCHDOG=1"} {
+		if msg, _ := readNotify(t, conn); msg != want {
+			t.Errorf("Got %q, expected %q", msg, want)
+		}
+	}
+}
@@ -18,8 +18,10 @@ type osmock interface {
 	Getpid() int
 	Lstat(name string) (fi os.FileInfo, err error)
 	NewFile(fd uintptr, name string) *os.File
-	Setenv(key, val string) error
+	Unsetenv(key string) error
 
+	Close(fd int) error
+	Dup(fd int) (int, error)
 	Fstat(fd int, st *syscall.Stat_t) error
 	Syscall(trap, a1, a2, a3 uintptr) (r1, r2 uintptr, err syscall.Errno)
 }
@@ -46,8 +48,20 @@ func (o osPackage) NewFile(fd uintptr, name string) *os.File {
 	return os.NewFile(fd, name)
 }
 
-func (o osPackage) Setenv(key, val string) error {
-	return os.Setenv(key, val)
+func (o osPackage) Unsetenv(key string) error {
+	return os.Unsetenv(key)
+}
+
+func (o osPackage) Close(fd int) error {
+	return syscall.Close(fd)
+}
+
+func (o osPackage) Dup(fd int) (int, error) {
+	nfd, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_DUPFD_CLOEXEC, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(nfd), nil
 }
 
 func (o osPackage) Fstat(fd int, st *syscall.Stat_t) error {
@@ -0,0 +1,42 @@
+package systemd
+
+import (
+	"context"
+	"os"
+)
+
+// PidNotify works like Notify, but the message is attributed to the process
+// with the given pid instead of the calling one, like sd_pid_notify does. This
+// is useful for supervisors, that send notifications on behalf of their
+// workers. The pid is passed as SCM_CREDENTIALS, which requires CAP_SYS_ADMIN,
+// unless it is the pid of the calling process. For the system manager to
+// accept the message, the service usually needs NotifyAccess=all. If pid is
+// 0, the message is attributed to the calling process.
+func PidNotify(pid int, state string) error {
+	return PidNotifyWithFiles(pid, state)
+}
+
+// PidNotifyWithFiles works like PidNotify, but additionally passes the given
+// files, like NotifyWithFiles.
+func PidNotifyWithFiles(pid int, state string, files ...*os.File) error {
+	return defaultNotifier.PidNotifyWithFiles(context.Background(), pid, state, files...)
+}
+
+// PidNotifyWithFiles sends a message on behalf of pid, like the package-level
+// PidNotifyWithFiles.
+func (n *Notifier) PidNotifyWithFiles(ctx context.Context, pid int, state string, files ...*os.File) error {
+	if pid == 0 {
+		return n.NotifyWithFiles(ctx, state, files...)
+	}
+
+	m, err := newMessage(state, files)
+	if err != nil {
+		return err
+	}
+	m.Credentials = &Credentials{
+		Pid: int32(pid),
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	return n.Send(ctx, m)
+}
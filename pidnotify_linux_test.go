@@ -0,0 +1,71 @@
+package systemd
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPidNotify(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	f, err := conn.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syscall.SetsockoptInt(int(f.Fd()), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// Only our own pid works without privileges
+	if err = PidNotifyWithFiles(os.Getpid(), "FDSTORE=1", r); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	oob := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "FDSTORE=1" {
+		t.Errorf("Got %q", buf[:n])
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cred *syscall.Ucred
+	var fds []int
+	for _, m := range msgs {
+		if c, err := syscall.ParseUnixCredentials(&m); err == nil {
+			cred = c
+		}
+		if rights, err := syscall.ParseUnixRights(&m); err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+
+	if cred == nil || int(cred.Pid) != os.Getpid() {
+		t.Errorf("Got credentials %+v, expected pid %d", cred, os.Getpid())
+	}
+	if len(fds) != 1 {
+		t.Errorf("Got %d files, expected 1", len(fds))
+	}
+}
@@ -0,0 +1,202 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultRelayTimeout is used, if Relay.Timeout is zero.
+const defaultRelayTimeout = 5 * time.Second
+
+// Relay forwards notifications of a child process to the system manager. It
+// is useful for launchers, that are the main process of a service, but run a
+// child that sends notifications itself. The child gets its own notification
+// socket and its messages are forwarded via a Notifier, so they are
+// attributed to the launcher:
+//
+//		cmd := exec.Command("java", "-jar", "server.jar")
+//		r := &systemd.Relay{
+//			Filter: func(m *systemd.Message) *systemd.Message {
+//				if _, ok := m.Get("MAINPID"); ok {
+//					return nil
+//				}
+//				return m
+//			},
+//		}
+//		if err := r.Start(cmd); err != nil {
+//			log.Fatal(err)
+//		}
+//		defer r.Close()
+//		if err := cmd.Run(); err != nil {
+//			log.Fatal(err)
+//		}
+//
+// Messages that are not valid (see ParseMessage) are dropped. Credentials
+// sent by the child are not forwarded. A BARRIER=1 of the child is only
+// passed, once the system manager passed a barrier of the Relay.
+type Relay struct {
+	// Filter is called for every valid message received from the child.
+	// It returns the message to forward, which may be modified, or nil to
+	// drop it. If it is nil, all messages are forwarded.
+	Filter func(m *Message) *Message
+
+	// Notifier is used to forward messages. If it is nil, the Notifier
+	// used by the package-level Notify* functions is used.
+	Notifier *Notifier
+
+	// Timeout limits the time forwarding a single message may take,
+	// including waiting for the system manager to pass a barrier. If it is
+	// zero, five seconds are used.
+	Timeout time.Duration
+
+	// OnError is called with the error, if a message received from the
+	// child is not valid, forwarding it fails or receiving messages fails,
+	// which stops forwarding. It is called from the goroutine forwarding
+	// the messages, so it should not block.
+	OnError func(error)
+
+	mtx  sync.Mutex
+	conn *net.UnixConn
+	dir  string
+	done chan struct{}
+}
+
+// Start creates a notification socket for cmd, sets NOTIFY_SOCKET in its
+// environment and starts forwarding the messages received on it. It must be
+// called before cmd is started. A Relay can only be started once.
+func (r *Relay) Start(cmd *exec.Cmd) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.done != nil {
+		return errors.New("Relay already started")
+	}
+
+	dir, err := os.MkdirTemp("", "systemd-relay")
+	if err != nil {
+		return err
+	}
+	addr := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = []string{"NOTIFY_SOCKET=" + addr}
+	for _, e := range env {
+		if !strings.HasPrefix(e, "NOTIFY_SOCKET=") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+
+	r.conn, r.dir, r.done = conn, dir, make(chan struct{})
+	go r.run()
+	return nil
+}
+
+// Addr returns the address of the notification socket of the child, or an
+// empty string, if the Relay is not started.
+func (r *Relay) Addr() string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.conn == nil {
+		return ""
+	}
+	return r.conn.LocalAddr().String()
+}
+
+// run receives messages from the child and forwards them, until the socket is
+// closed.
+func (r *Relay) run() {
+	defer close(r.done)
+
+	n := r.Notifier
+	if n == nil {
+		n = defaultNotifier
+	}
+
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, 4096)
+	for {
+		nb, noob, flags, _, err := r.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) && r.OnError != nil {
+				r.OnError(err)
+			}
+			return
+		}
+
+		m, err := ParseMessage(buf[:nb], oob[:noob])
+		if err == nil && flags&syscall.MSG_CTRUNC != 0 {
+			err = errors.New("Ancillary data of message truncated")
+		}
+		if err == nil {
+			err = r.forward(n, m)
+		}
+		if err != nil && r.OnError != nil {
+			r.OnError(err)
+		}
+		if m != nil {
+			for _, f := range m.Files {
+				f.Close()
+			}
+		}
+	}
+}
+
+// forward passes m on to n, after applying the Filter.
+func (r *Relay) forward(n *Notifier, m *Message) error {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultRelayTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// The barrier of the child is passed by closing its file, after the
+	// caller forwarded everything received before.
+	if _, ok := m.Get("BARRIER"); ok {
+		return n.Barrier(ctx)
+	}
+
+	m.Credentials = nil
+	if r.Filter != nil {
+		m = r.Filter(m)
+	}
+	if m == nil || len(m.Fields) == 0 {
+		return nil
+	}
+	return n.Send(ctx, m)
+}
+
+// Close stops forwarding and removes the notification socket.
+func (r *Relay) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	<-r.done
+	r.conn = nil
+
+	if rerr := os.RemoveAll(r.dir); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package systemd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRelayChild is run as the child process by TestRelay.
+func TestRelayChild(t *testing.T) {
+	if os.Getenv("SYSTEMD_TEST_RELAY_CHILD") != "1" {
+		t.Skip("Only run as child of TestRelay")
+	}
+	osm = &osPackage{}
+
+	if err := Notify("READY=1\nSTATUS=child\nMAINPID=" + strconv.Itoa(os.Getpid())); err != nil {
+		t.Fatal(err)
+	}
+	if err := Notify("MAINPID=" + strconv.Itoa(os.Getpid())); err != nil {
+		t.Fatal(err)
+	}
+	// Notify refuses to send invalid messages, so write it directly.
+	conn, err := NotifyConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("READY=2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := NotifyStatus("done"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRelayChild$")
+	cmd.Env = append(os.Environ(), "SYSTEMD_TEST_RELAY_CHILD=1", "NOTIFY_SOCKET=/nonexistent")
+
+	var (
+		mtx  sync.Mutex
+		errs []error
+	)
+	r := &Relay{
+		OnError: func(err error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			errs = append(errs, err)
+		},
+		Filter: func(m *Message) *Message {
+			var fields []Field
+			for _, f := range m.Fields {
+				if f.Key != "MAINPID" {
+					fields = append(fields, f)
+				}
+			}
+			m.Fields = fields
+			return m
+		},
+	}
+	if err := r.Start(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.Start(cmd); err == nil {
+		t.Errorf("Starting a Relay twice succeeded")
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Child failed: %v\n%s", err, out)
+	}
+
+	for _, want := range []string{"READY=1\nSTATUS=child", "STATUS=done"} {
+		if msg, _ := readNotify(t, conn); msg != want {
+			t.Errorf("Got %q, expected %q", msg, want)
+		}
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(r.dir); !os.IsNotExist(err) {
+		t.Errorf("Socket directory not removed: %v", err)
+	}
+	if len(errs) != 1 {
+		t.Errorf("Got errors %v, expected one for READY=2", errs)
+	}
+}
+
+func TestRelayBarrierTimeout(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// The fake socket never passes the barrier.
+	rl := &Relay{Timeout: 10 * time.Millisecond}
+	err = rl.forward(defaultNotifier, &Message{Fields: []Field{{"BARRIER", "1"}}, Files: []*os.File{w}})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Got %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if msg, files := readNotify(t, conn); msg != "BARRIER=1" {
+		t.Errorf("Got %q, expected BARRIER=1", msg)
+	} else {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+}
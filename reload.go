@@ -0,0 +1,62 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifyReloading tells the system manager that the daemon is reloading its
+// configuration. Once reloading is finished, NotifyReady must be called. This
+// is needed for services with Type=notify-reload.
+func NotifyReloading() error {
+	return NewNotification().Reloading().Send()
+}
+
+// NotifyStopping tells the system manager that the daemon is shutting down.
+func NotifyStopping() error {
+	return Notify("STOPPING=1")
+}
+
+// HandleReload calls reload whenever the process receives SIGHUP, which is
+// how the system manager asks services with Type=notify-reload to reload
+// their configuration (and a common convention for ExecReload=). Before
+// calling reload it sends RELOADING=1 and afterwards READY=1. If reload
+// fails, only the error is reported to the system manager with STATUS= and
+// ERRNO= (taken from a wrapped syscall.Errno, or EIO otherwise).
+//
+// HandleReload returns ctx.Err() once ctx is done, or the error if a
+// notification could not be sent; not being run by systemd
+// (ErrNoNotifySocket) is not considered an error.
+func HandleReload(ctx context.Context, reload func() error) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-sig:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if err := NotifyReloading(); err != nil && err != ErrNoNotifySocket {
+			return err
+		}
+
+		n := NewNotification()
+		if err := reload(); err != nil {
+			errno := syscall.EIO
+			errors.As(err, &errno)
+			n.Status(fmt.Sprintf("Reload failed: %v", err)).Errno(uint(errno))
+		} else {
+			n.Ready()
+		}
+		if err := n.Send(); err != nil && err != ErrNoNotifySocket {
+			return err
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package systemd
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// clockMonotonic is CLOCK_MONOTONIC, which the syscall package does not
+// define.
+const clockMonotonic = 1
+
+// monotonicNow returns the current time of CLOCK_MONOTONIC, which is what the
+// system manager expects in MONOTONIC_USEC.
+func monotonicNow() (time.Duration, error) {
+	ts := new(syscall.Timespec)
+	_, _, errno := osm.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(ts)), 0)
+	runtime.KeepAlive(ts)
+	if errno != 0 {
+		return 0, errno
+	}
+	return time.Duration(ts.Nano()), nil
+}
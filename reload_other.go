@@ -0,0 +1,11 @@
+//go:build !linux
+
+package systemd
+
+import "time"
+
+// monotonicNow returns an error, as the clock of the system manager is only
+// available on Linux.
+func monotonicNow() (time.Duration, error) {
+	return 0, errUnsupported
+}
@@ -0,0 +1,115 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotifyReloading(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	before, err := monotonicNow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = NotifyReloading(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := monotonicNow()
+
+	msg, _ := readNotify(t, conn)
+	if !strings.HasPrefix(msg, "RELOADING=1\nMONOTONIC_USEC=") {
+		t.Fatalf("Got %q", msg)
+	}
+	us, err := strconv.ParseInt(strings.TrimPrefix(msg, "RELOADING=1\nMONOTONIC_USEC="), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Duration(us) * time.Microsecond; d < before-time.Microsecond || d > after {
+		t.Errorf("MONOTONIC_USEC %v not in [%v, %v]", d, before, after)
+	}
+}
+
+func TestHandleReload(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan error)
+	done := make(chan error)
+	go func() {
+		done <- HandleReload(ctx, func() error {
+			return <-results
+		})
+	}()
+
+	// Wait for the signal handler to be installed
+	time.Sleep(50 * time.Millisecond)
+
+	for _, err := range []error{nil, fmt.Errorf("config: %w", syscall.ENOENT), errors.New("broken")} {
+		syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+		if msg, _ := readNotify(t, conn); !strings.HasPrefix(msg, "RELOADING=1\n") {
+			t.Errorf("Got %q, expected RELOADING=1", msg)
+		}
+		results <- err
+
+		expected := "READY=1"
+		if errors.Is(err, syscall.ENOENT) {
+			expected = fmt.Sprintf("STATUS=Reload failed: %v\nERRNO=%d", err, syscall.ENOENT)
+		} else if err != nil {
+			expected = fmt.Sprintf("STATUS=Reload failed: %v\nERRNO=%d", err, syscall.EIO)
+		}
+		if msg, _ := readNotify(t, conn); msg != expected {
+			t.Errorf("Got %q, expected %q", msg, expected)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("HandleReload returned %v", err)
+	}
+}
+
+func TestHandleReloadWithoutSystemd(t *testing.T) {
+	_, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+	os.Unsetenv("NOTIFY_SOCKET")
+	ResetDefaultNotifier()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloaded := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- HandleReload(ctx, func() error {
+			reloaded <- true
+			return nil
+		})
+	}()
+
+	// Wait for the signal handler to be installed
+	time.Sleep(50 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+		select {
+		case <-reloaded:
+		case err := <-done:
+			t.Fatalf("HandleReload returned %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Reload callback not called")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("HandleReload returned %v", err)
+	}
+}
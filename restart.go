@@ -0,0 +1,239 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Restarter implements restarts without dropping connections. Listeners
+// created with Listen are pushed into the file descriptor store of the system
+// manager on shutdown and picked up again on the next start, so the sockets
+// stay open during the restart and new connections queue up in their backlog.
+// For this to work, the service needs FileDescriptorStoreMax= to be set.
+//
+// A typical http server would do
+//
+//		var r systemd.Restarter
+//		l, err := r.Listen("http", "tcp", ":8080")
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		go http.Serve(l, nil)
+//		r.Ready()
+//		if err := r.Run(context.Background()); err != nil {
+//			log.Println(err)
+//		}
+type Restarter struct {
+	// DrainTimeout is the time Run waits for open connections to be closed
+	// by their handlers, before closing them forcibly. If it is zero, Run
+	// waits indefinitely.
+	DrainTimeout time.Duration
+
+	mtx       sync.Mutex
+	listeners []*restartListener
+	conns     map[*restartConn]struct{}
+	accepting int
+	closed    bool
+	drained   []chan struct{}
+}
+
+// Listen returns a listener for the given name, like PassedListener.
+// A listener passed by the system manager can come either from the file
+// descriptor store or from a socket unit with FileDescriptorName=.
+func (r *Restarter) Listen(name, network, address string) (net.Listener, error) {
+	if err := checkFdName(name); err != nil {
+		return nil, err
+	}
+
+	l, passed, err := listen(name, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := &restartListener{Listener: l, r: r, name: name, passed: passed}
+
+	r.mtx.Lock()
+	r.listeners = append(r.listeners, rl)
+	r.mtx.Unlock()
+
+	return rl, nil
+}
+
+// Ready tells the system manager that startup is finished. It should be
+// called once all listeners have been created.
+func (r *Restarter) Ready() error {
+	return NotifyReady()
+}
+
+// Run waits until the process receives SIGTERM or ctx is done and then calls
+// Shutdown, giving open connections DrainTimeout to finish.
+func (r *Restarter) Run(ctx context.Context) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case <-sig:
+	case <-ctx.Done():
+	}
+
+	ctx = context.Background()
+	if r.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.DrainTimeout)
+		defer cancel()
+	}
+	return r.Shutdown(ctx)
+}
+
+// Shutdown pushes all listeners into the file descriptor store, closes them
+// and waits for all accepted connections to be closed. If ctx is done before
+// that, the remaining connections are closed forcibly and ctx.Err() is
+// returned. Otherwise the first error of sending STOPPING=1 or of storing
+// the listeners is returned.
+func (r *Restarter) Shutdown(ctx context.Context) error {
+	notifyErr := NotifyStopping()
+
+	r.mtx.Lock()
+	listeners := r.listeners
+	r.listeners = nil
+	r.mtx.Unlock()
+
+	err := notifyErr
+	for _, l := range listeners {
+		if serr := l.store(); serr != nil && err == nil {
+			err = serr
+		}
+		l.Listener.Close()
+	}
+
+	select {
+	case <-r.drain():
+	case <-ctx.Done():
+		r.mtx.Lock()
+		r.closed = true
+		for c := range r.conns {
+			c.Conn.Close()
+		}
+		r.mtx.Unlock()
+		return ctx.Err()
+	}
+	return err
+}
+
+// drain returns a channel that is closed once there are no open connections
+// and no calls to Accept in progress, which could still return one.
+func (r *Restarter) drain() <-chan struct{} {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	ch := make(chan struct{})
+	r.drained = append(r.drained, ch)
+	r.checkDrained()
+	return ch
+}
+
+// checkDrained closes the channels returned by drain, if there are no open
+// connections and no calls to Accept in progress. r.mtx must be held.
+func (r *Restarter) checkDrained() {
+	if len(r.conns) > 0 || r.accepting > 0 {
+		return
+	}
+	for _, ch := range r.drained {
+		close(ch)
+	}
+	r.drained = nil
+}
+
+// accept calls accept and registers the returned connection, while it is
+// counted as in progress, so that drain waits for it.
+func (r *Restarter) accept(accept func() (net.Conn, error)) (net.Conn, error) {
+	r.mtx.Lock()
+	r.accepting++
+	r.mtx.Unlock()
+
+	c, err := accept()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.accepting--
+	if err == nil && r.closed {
+		// Shutdown gave up waiting and closed all connections.
+		c.Close()
+		err = net.ErrClosed
+	}
+	if err != nil {
+		r.checkDrained()
+		return nil, err
+	}
+
+	rc := &restartConn{Conn: c, r: r}
+	if r.conns == nil {
+		r.conns = make(map[*restartConn]struct{})
+	}
+	r.conns[rc] = struct{}{}
+	return rc, nil
+}
+
+func (r *Restarter) remove(c *restartConn) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.conns, c)
+	r.checkDrained()
+}
+
+// restartListener tracks the connections accepted from a listener.
+type restartListener struct {
+	net.Listener
+	r      *Restarter
+	name   string
+	passed bool
+}
+
+func (l *restartListener) Accept() (net.Conn, error) {
+	return l.r.accept(l.Listener.Accept)
+}
+
+// store pushes the listener into the file descriptor store, unless it was
+// passed by the system manager, which then still holds it.
+func (l *restartListener) store() error {
+	if l.passed {
+		return nil
+	}
+
+	fl, ok := l.Listener.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return fmt.Errorf("Can not store listener %q of type %T", l.name, l.Listener)
+	}
+
+	f, err := fl.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return StoreFiles(l.name, f)
+}
+
+// restartConn removes itself from the Restarter when closed.
+type restartConn struct {
+	net.Conn
+	r    *Restarter
+	once sync.Once
+}
+
+func (c *restartConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.r.remove(c) })
+	return err
+}
@@ -0,0 +1,129 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRestarter(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+	os.Unsetenv("LISTEN_PID")
+
+	var r Restarter
+	l, err := r.Listen("http", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "READY=1" {
+		t.Errorf("Got %q, expected READY=1", msg)
+	}
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- r.Shutdown(context.Background())
+	}()
+
+	if msg, _ := readNotify(t, conn); msg != "STOPPING=1" {
+		t.Errorf("Got %q, expected STOPPING=1", msg)
+	}
+	msg, files := readNotify(t, conn)
+	if msg != "FDSTORE=1\nFDNAME=http" || len(files) != 1 {
+		t.Errorf("Got %q with %d files", msg, len(files))
+	}
+
+	// The stored listener must still accept connections
+	stored, err := net.FileListener(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[0].Close()
+	defer stored.Close()
+
+	c2, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Errorf("Stored listener does not accept connections: %v", err)
+	} else {
+		c2.Close()
+	}
+
+	select {
+	case err = <-done:
+		t.Fatalf("Shutdown returned %v before connection was closed", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	server.Close()
+	if err = <-done; err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+}
+
+func TestRestarterDrainTimeout(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+	os.Unsetenv("LISTEN_PID")
+
+	var r Restarter
+	l, err := r.Listen("http", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = r.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	if _, err = server.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Connection was not closed")
+	}
+
+	readNotify(t, conn)
+	_, files := readNotify(t, conn)
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func TestRestarterWithoutSystemd(t *testing.T) {
+	_, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+	os.Unsetenv("NOTIFY_SOCKET")
+	ResetDefaultNotifier()
+
+	var r Restarter
+	if err := r.Shutdown(context.Background()); err != ErrNoNotifySocket {
+		t.Errorf("Shutdown returned %v, expected %v", err, ErrNoNotifySocket)
+	}
+}
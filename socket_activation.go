@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 )
@@ -17,8 +18,17 @@ const (
 )
 
 var (
-	num_fds = -1
-	lock    = sync.Mutex{}
+	// passed caches the names of the passed file descriptors, so the
+	// environment is only read once.
+	passed     []string
+	passedErr  error
+	passedRead bool
+
+	// claimed records, which of the passed file descriptors have been
+	// adopted already.
+	claimed []bool
+
+	lock = sync.Mutex{}
 )
 
 // listenFds checks for file descriptors passed by the system manager. It
@@ -53,8 +63,10 @@ func listenFds() (num_fds int, err error) {
 		return 0, fmt.Errorf("Got negative number of filedescriptors")
 	}
 
+	// FD_CLOEXEC is a file descriptor flag, not a file status flag, so we
+	// need F_GETFD/F_SETFD.
 	for i := listenFdsStart; i < listenFdsStart+l; i++ {
-		flags, _, err := osm.Syscall(syscall.SYS_FCNTL, uintptr(i), syscall.F_GETFL, 0)
+		flags, _, err := osm.Syscall(syscall.SYS_FCNTL, uintptr(i), syscall.F_GETFD, 0)
 		if err != 0 {
 			return 0, err
 		}
@@ -65,7 +77,7 @@ func listenFds() (num_fds int, err error) {
 
 		flags |= syscall.FD_CLOEXEC
 
-		_, _, err = osm.Syscall(syscall.SYS_FCNTL, uintptr(i), syscall.F_SETFL, flags)
+		_, _, err = osm.Syscall(syscall.SYS_FCNTL, uintptr(i), syscall.F_SETFD, flags)
 		if err != 0 {
 			return 0, err
 		}
@@ -88,129 +100,281 @@ func getFile(num int) (f *os.File, err error) {
 		return nil, err
 	}
 
-	if st.Mode & syscall.S_IFIFO == 0 && st.Mode & syscall.S_IFREG == 0 && st.Mode & syscall.S_IFCHR == 0 {
+	if !statIsFifo(&st) && !statIsSpecial(&st) {
 		return nil, errors.New("File descriptor is not a fifo or special file")
 	}
 
-	return osm.NewFile(uintptr(fd), ""), nil
+	err = adopt(num, "", func(nf *os.File) error {
+		f = nf
+		return nil
+	})
+	return f, err
 }
 
-// getConn checks if the file descriptor at the given offset is a socket and
-// returns a net.Conn bound to this file descriptor.
-func getConn(num int) (conn net.Conn, err error) {
+// adopt claims the file descriptor at the given offset and calls open with a
+// duplicate of it, named name, which open owns from then on. If open fails,
+// e.g. because a socket has the wrong type, the claim is undone and the file
+// descriptor stays open, so it can still be retrieved as a different type.
+// Otherwise the original file descriptor is closed. All passed files are
+// adopted this way, so they are owned by exactly one *os.File or socket.
+func adopt(num int, name string, open func(f *os.File) error) error {
 	fd := listenFdsStart + num
 
-	f := osm.NewFile(uintptr(fd), "")
-	conn, err = net.FileConn(f)
-	f.Close()
-
-	return conn, err
-}
+	if err := claim(num); err != nil {
+		return err
+	}
 
-// getIPConn checks if the file descriptor at the given offset is a tcp-socket
-// and returns a net.IPConn bound to this file descriptor.
-func getIPConn(num int) (conn *net.IPConn, err error) {
-	c, err := getConn(num)
+	dup, err := osm.Dup(fd)
 	if err != nil {
-		return nil, err
+		unclaim(num)
+		return err
 	}
 
-	var ok bool
-	if conn, ok = c.(*net.IPConn); !ok {
-		return nil, errors.New("Not a IPConn")
+	if err = open(osm.NewFile(uintptr(dup), name)); err != nil {
+		unclaim(num)
+		return err
 	}
 
-	return conn, nil
+	osm.Close(fd)
+	return nil
+}
+
+// adoptConn adopts the file descriptor at the given offset as a net.Conn, if
+// use accepts it. what describes the expected type in errors.
+func adoptConn(num int, what string, use func(net.Conn) bool) error {
+	return adopt(num, "", func(f *os.File) error {
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if !use(c) {
+			c.Close()
+			return fmt.Errorf("Not a %s", what)
+		}
+		return nil
+	})
+}
+
+// adoptListener adopts the file descriptor at the given offset as a
+// net.Listener, if use accepts it. what describes the expected type in
+// errors.
+func adoptListener(num int, what string, use func(net.Listener) bool) error {
+	return adopt(num, "", func(f *os.File) error {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if !use(l) {
+			l.Close()
+			return fmt.Errorf("Not a %s", what)
+		}
+		return nil
+	})
+}
+
+// getConn checks if the file descriptor at the given offset is a socket and
+// returns a net.Conn bound to this file descriptor.
+func getConn(num int) (conn net.Conn, err error) {
+	err = adoptConn(num, "Conn", func(c net.Conn) bool {
+		conn = c
+		return true
+	})
+	return conn, err
+}
+
+// getIPConn checks if the file descriptor at the given offset is an ip-socket
+// and returns a net.IPConn bound to this file descriptor.
+func getIPConn(num int) (conn *net.IPConn, err error) {
+	err = adoptConn(num, "IPConn", func(c net.Conn) (ok bool) {
+		conn, ok = c.(*net.IPConn)
+		return ok
+	})
+	return conn, err
 }
 
 // getTCPConn checks if the file descriptor at the given offset is a tcp-socket
 // and returns a net.TCPConn bound to this file descriptor.
 func getTCPConn(num int) (conn *net.TCPConn, err error) {
-	c, err := getConn(num)
-	if err != nil {
-		return nil, err
-	}
-
-	var ok bool
-	if conn, ok = c.(*net.TCPConn); !ok {
-		return nil, errors.New("Not a TCPConn")
-	}
-
-	return conn, nil
+	err = adoptConn(num, "TCPConn", func(c net.Conn) (ok bool) {
+		conn, ok = c.(*net.TCPConn)
+		return ok
+	})
+	return conn, err
 }
 
-// getUDPConn checks if the file descriptor at the given offset is a tcp-socket
+// getUDPConn checks if the file descriptor at the given offset is a udp-socket
 // and returns a net.UDPConn bound to this file descriptor.
 func getUDPConn(num int) (conn *net.UDPConn, err error) {
-	c, err := getConn(num)
-	if err != nil {
-		return nil, err
+	err = adoptConn(num, "UDPConn", func(c net.Conn) (ok bool) {
+		conn, ok = c.(*net.UDPConn)
+		return ok
+	})
+	return conn, err
+}
+
+// getUnixConn checks if the file descriptor at the given offset is a
+// unix-socket and returns a net.UnixConn bound to this file descriptor.
+func getUnixConn(num int) (conn *net.UnixConn, err error) {
+	err = adoptConn(num, "UnixConn", func(c net.Conn) (ok bool) {
+		conn, ok = c.(*net.UnixConn)
+		return ok
+	})
+	return conn, err
+}
+
+// getPacketConn checks if the file descriptor at the given offset is a
+// datagram socket and returns a net.PacketConn bound to this file descriptor.
+func getPacketConn(num int) (conn net.PacketConn, err error) {
+	err = adopt(num, "", func(f *os.File) (err error) {
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+		return err
+	})
+	return conn, err
+}
+
+// getListener checks if the file descriptor at the given offset is a socket
+// and returns a net.Listener bound to this file descriptor.
+func getListener(num int) (l net.Listener, err error) {
+	err = adoptListener(num, "Listener", func(nl net.Listener) bool {
+		l = nl
+		return true
+	})
+	return l, err
+}
+
+// getTCPListener checks if the file descriptor at the given offset is a
+// tcp-socket in accepting mode and returns a net.TCPListener bound to this file
+// descriptor.
+func getTCPListener(num int) (l *net.TCPListener, err error) {
+	err = adoptListener(num, "TCPListener", func(nl net.Listener) (ok bool) {
+		l, ok = nl.(*net.TCPListener)
+		return ok
+	})
+	return l, err
+}
+
+// getUnixListener checks if the file descriptor at the given offset is a
+// unix-socket in accepting mode and returns a net.UnixListener bound to this
+// file descriptor.
+func getUnixListener(num int) (l *net.UnixListener, err error) {
+	err = adoptListener(num, "UnixListener", func(nl net.Listener) (ok bool) {
+		l, ok = nl.(*net.UnixListener)
+		return ok
+	})
+	return l, err
+}
+
+// listenFdNames returns the names of the n passed file descriptors, as set
+// with FileDescriptorName= in the socket unit and passed in LISTEN_FDNAMES. If
+// the variable is not set, every file descriptor is named "unknown", like
+// sd_listen_fds_with_names does.
+func listenFdNames(n int) (names []string, err error) {
+	e := osm.Getenv("LISTEN_FDNAMES")
+	if e == "" {
+		names = make([]string, n)
+		for i := range names {
+			names[i] = "unknown"
+		}
+		return names, nil
 	}
 
-	var ok bool
-	if conn, ok = c.(*net.UDPConn); !ok {
-		return nil, errors.New("Not a UDPConn")
+	names = strings.Split(e, ":")
+	if len(names) != n {
+		return nil, fmt.Errorf("Got %d names for %d filedescriptors", len(names), n)
 	}
+	return names, nil
+}
+
+// passedFds returns the names of the file descriptors passed by the system
+// manager. The environment is only read on the first call.
+func passedFds() (names []string, err error) {
+	lock.Lock()
+	defer lock.Unlock()
 
-	return conn, nil
+	if !passedRead {
+		passed, passedErr = readPassedFds()
+		claimed = make([]bool, len(passed))
+		passedRead = true
+	}
+	return passed, passedErr
 }
 
-// getUnixConn checks if the file descriptor at the given offset is a tcp-socket
-// and returns a net.UnixConn bound to this file descriptor.
-func getUnixConn(num int) (conn *net.UnixConn, err error) {
-	c, err := getConn(num)
+// readPassedFds reads the names of the passed file descriptors from the
+// environment.
+func readPassedFds() (names []string, err error) {
+	defer consumeEnv(Listen)
+
+	fds, err := listenFds()
 	if err != nil {
 		return nil, err
 	}
+	return listenFdNames(fds)
+}
 
-	var ok bool
-	if conn, ok = c.(*net.UnixConn); !ok {
-		return nil, errors.New("Not a UnixConn")
-	}
+// claim marks the passed file descriptor at the given offset as adopted. Every
+// file descriptor can only be claimed once, as there must only be one owner
+// for it. Offsets outside of the passed file descriptors are not tracked.
+func claim(num int) error {
+	lock.Lock()
+	defer lock.Unlock()
 
-	return conn, nil
+	if num < 0 || num >= len(claimed) {
+		return nil
+	}
+	if claimed[num] {
+		return fmt.Errorf("Filedescriptor %d has already been claimed", listenFdsStart+num)
+	}
+	claimed[num] = true
+	return nil
 }
 
-// getListener checks if the file descriptor at the given offset is a socket
-// and returns a net.Listener bound to this file descriptor.
-func getListener(num int) (conn net.Listener, err error) {
-	fd := listenFdsStart + num
+// unclaim undoes claim, if adopting the file descriptor failed.
+func unclaim(num int) {
+	lock.Lock()
+	defer lock.Unlock()
 
-	return net.FileListener(osm.NewFile(uintptr(fd), ""))
+	if num >= 0 && num < len(claimed) {
+		claimed[num] = false
+	}
 }
 
-// getTCPListener checks if the file descriptor at the given offset is a
-// tcp-socket in accepting mode and returns a net.TCPListener bound to this file
-// descriptor.
-func getTCPListener(num int) (conn *net.TCPListener, err error) {
-	l, err := getListener(num)
-	if err != nil {
+// UnclaimedFiles returns the passed file descriptors, that have not been
+// adopted by any function of this package yet. It can be used to warn about
+// sockets configured in a unit file, that the program does not know about.
+func UnclaimedFiles() ([]int, error) {
+	if _, err := passedFds(); err != nil {
 		return nil, err
 	}
 
-	var ok bool
-	if conn, ok = l.(*net.TCPListener); !ok {
-		return nil, errors.New("Not a TCPListener")
-	}
+	lock.Lock()
+	defer lock.Unlock()
 
-	return conn, nil
+	var fds []int
+	for i, c := range claimed {
+		if !c {
+			fds = append(fds, listenFdsStart+i)
+		}
+	}
+	return fds, nil
 }
 
-// getUnixListener checks if the file descriptor at the given offset is a
-// tcp-socket in accepting mode and returns a net.UnixListener bound to this file
-// descriptor.
-func getUnixListener(num int) (conn *net.UnixListener, err error) {
-	l, err := getListener(num)
+// PassedFileNames returns the passed file descriptors, grouped by their name.
+// Several file descriptors can share a name, e.g. if a socket unit contains
+// more than one Listen*= line; they are listed in ascending order.
+func PassedFileNames() (map[string][]int, error) {
+	names, err := passedFds()
 	if err != nil {
 		return nil, err
 	}
 
-	var ok bool
-	if conn, ok = l.(*net.UnixListener); !ok {
-		return nil, errors.New("Not a UnixListener")
+	m := make(map[string][]int)
+	for i, name := range names {
+		m[name] = append(m[name], listenFdsStart+i)
 	}
-
-	return conn, nil
+	return m, nil
 }
 
 // GetPassedFiles acquires a number of open filedescriptors using the systemd
@@ -229,7 +393,15 @@ func getUnixListener(num int) (conn *net.UnixListener, err error) {
 // be placed in the slice, as above
 //
 // Everything else is an error. It is not an error to provide more targets then
-// there are passed file descriptors, but it is an error to provide fewer.
+// there are passed file descriptors, but it is an error to provide fewer. It
+// is also an error to store a file descriptor, that was already stored by an
+// earlier call.
+//
+// The supported types to store a file descriptor in are net.Conn, net.Listener,
+// net.PacketConn, *net.IPConn, *net.TCPConn, *net.UDPConn, *net.UnixConn,
+// *net.TCPListener, *net.UnixListener (which also handles sockets from
+// ListenSequentialPacket=), *NetlinkConn, *MessageQueue and *os.File (for
+// FIFOs and special files).
 //
 // For example, a web server, that wants to provide a local administrative unix
 // domain socket and have systemd open an arbitrary number of connections for
@@ -239,60 +411,97 @@ func getUnixListener(num int) (conn *net.UnixListener, err error) {
 //		var listeners []*net.TCPListener
 //		fds, err := SockedActivation(true, &control, &listeners)
 func GetPassedFiles(targets ...interface{}) (n int, err error) {
-	fds, err := listenFds()
+	names, err := passedFds()
+	if err != nil {
+		return 0, err
+	}
+
+	nums := make([]int, len(names))
+	for i := range nums {
+		nums[i] = i
+	}
+	return storeFds(nums, targets)
+}
+
+// GetPassedFilesByName works like GetPassedFiles, but only considers the file
+// descriptors with the given name (see PassedFileNames). This is more robust
+// than relying on the order of the Listen*= lines in the socket units. For
+// example
+//
+//		var http net.Listener
+//		var control *net.UnixListener
+//		_, err := GetPassedFilesByName("http", &http)
+//		...
+//		_, err = GetPassedFilesByName("control", &control)
+func GetPassedFilesByName(name string, targets ...interface{}) (n int, err error) {
+	names, err := passedFds()
 	if err != nil {
-		return fds, err
+		return 0, err
 	}
 
-	k := 0
+	var nums []int
+	for i, nm := range names {
+		if nm == name {
+			nums = append(nums, i)
+		}
+	}
+	return storeFds(nums, targets)
+}
 
-	for i := 0; i < fds; i++ {
-		rv := reflect.ValueOf(targets[k])
+// storeFds distributes the file descriptors at the given offsets over targets,
+// as described for GetPassedFiles.
+func storeFds(nums []int, targets []interface{}) (n int, err error) {
+	i := 0
+	for _, t := range targets {
+		if i >= len(nums) {
+			break
+		}
+
+		rv := reflect.ValueOf(t)
 
+		switch {
 		// Target is a pointer to a non-slice
-		if rv.Kind() == reflect.Ptr && rv.Elem().Kind() != reflect.Slice {
-			// Why zero value?
-			err = storeFd(i, rv.Elem())
+		case rv.Kind() == reflect.Ptr && rv.Elem().Kind() != reflect.Slice:
+			err = storeFd(nums[i], rv.Elem())
 			if err != nil {
 				return 0, err
 			}
-			continue
-		}
+			i++
 
 		// Target is a slice
-		if rv.Kind() == reflect.Slice {
-			for j := 0; j < rv.Len() && i < fds; j++ {
-				rv2 := rv.Index(j)
-				err = storeFd(i, rv2.Addr())
+		case rv.Kind() == reflect.Slice:
+			for j := 0; j < rv.Len() && i < len(nums); j++ {
+				err = storeFd(nums[i], rv.Index(j))
 				if err != nil {
 					return 0, err
 				}
 				i++
 			}
-			continue
-		}
 
 		// Target is a pointer to a slice
-		if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Slice {
+		case rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Slice:
 			if rv.Elem().IsNil() {
-				rv.Elem().Set(reflect.MakeSlice(rv.Elem().Type(), fds - i, fds - i))
+				rv.Elem().Set(reflect.MakeSlice(rv.Elem().Type(), len(nums)-i, len(nums)-i))
 			}
 
 			rv2 := rv.Elem()
-			for j := 0; j < rv2.Len() && i < fds; j++ {
-				rv3 := rv.Elem().Index(j)
-				err = storeFd(i, rv3.Addr())
+			for j := 0; j < rv2.Len() && i < len(nums); j++ {
+				err = storeFd(nums[i], rv2.Index(j))
 				if err != nil {
 					return 0, err
 				}
 				i++
 			}
-			continue
+
+		default:
+			return 0, fmt.Errorf("Unhandled type %v", rv.Type())
 		}
+	}
 
-		return 0, fmt.Errorf("Unhandled type %v", rv.Type())
+	if i < len(nums) {
+		return 0, fmt.Errorf("Not enough targets for %d filedescriptors", len(nums))
 	}
-	return fds, nil
+	return len(nums), nil
 }
 
 // storeFd assumes, that target is a settable Value and tries to store one fd in it
@@ -377,6 +586,36 @@ func storeFd(num int, target reflect.Value) error {
 		return nil
 	}
 
+	// Target is a net.PacketConn
+	if target.Type() == reflect.TypeOf((*net.PacketConn)(nil)).Elem() {
+		c, err := getPacketConn(num)
+		if err != nil {
+			return err
+		}
+		target.Set(reflect.ValueOf(c))
+		return nil
+	}
+
+	// Target is a *NetlinkConn
+	if target.Type() == reflect.TypeOf((*NetlinkConn)(nil)) {
+		c, err := getNetlinkConn(num)
+		if err != nil {
+			return err
+		}
+		target.Set(reflect.ValueOf(c))
+		return nil
+	}
+
+	// Target is a *MessageQueue
+	if target.Type() == reflect.TypeOf((*MessageQueue)(nil)) {
+		q, err := getMessageQueue(num)
+		if err != nil {
+			return err
+		}
+		target.Set(reflect.ValueOf(q))
+		return nil
+	}
+
 	// Target is a *os.File
 	if target.Type() == reflect.TypeOf((*os.File)(nil)) {
 		f, err := getFile(num)
@@ -1,7 +1,10 @@
 package systemd
 
 import (
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
 	"syscall"
 	"testing"
 )
@@ -12,10 +15,10 @@ func TestListenFds(t *testing.T) {
 		ListenPid  string
 		pid        int
 		ListenFds  string
-		GetflFlags uintptr
-		GetflErr   syscall.Errno
-		SetflFlags uintptr
-		SetflErr   syscall.Errno
+		GetfdFlags uintptr
+		GetfdErr   syscall.Errno
+		SetfdFlags uintptr
+		SetfdErr   syscall.Errno
 
 		NumFds int
 		Err    bool
@@ -27,10 +30,10 @@ func TestListenFds(t *testing.T) {
 		{ListenPid: "1234", pid: 1234, ListenFds: "unparseable", NumFds: 0, Err: true},
 		{ListenPid: "1234", pid: 1234, ListenFds: "-1", NumFds: 0, Err: true},
 		{ListenPid: "1234", pid: 1234, ListenFds: "0", NumFds: 0, Err: false},
-		{ListenPid: "1234", pid: 1234, ListenFds: "1", GetflErr: 1, NumFds: 0, Err: true},
-		{ListenPid: "1234", pid: 1234, ListenFds: "1", GetflErr: 0, GetflFlags: syscall.FD_CLOEXEC, NumFds: 1, Err: false},
-		{ListenPid: "1234", pid: 1234, ListenFds: "1", GetflErr: 0, GetflFlags: 0, SetflFlags: syscall.FD_CLOEXEC, SetflErr: 1, NumFds: 0, Err: true},
-		{ListenPid: "1234", pid: 1234, ListenFds: "1", GetflErr: 0, GetflFlags: 0, SetflFlags: syscall.FD_CLOEXEC, SetflErr: 0, NumFds: 1, Err: false},
+		{ListenPid: "1234", pid: 1234, ListenFds: "1", GetfdErr: 1, NumFds: 0, Err: true},
+		{ListenPid: "1234", pid: 1234, ListenFds: "1", GetfdErr: 0, GetfdFlags: syscall.FD_CLOEXEC, NumFds: 1, Err: false},
+		{ListenPid: "1234", pid: 1234, ListenFds: "1", GetfdErr: 0, GetfdFlags: 0, SetfdFlags: syscall.FD_CLOEXEC, SetfdErr: 1, NumFds: 0, Err: true},
+		{ListenPid: "1234", pid: 1234, ListenFds: "1", GetfdErr: 0, GetfdFlags: 0, SetfdFlags: syscall.FD_CLOEXEC, SetfdErr: 0, NumFds: 1, Err: false},
 	}
 
 	for _, tc := range testcases {
@@ -38,8 +41,8 @@ func TestListenFds(t *testing.T) {
 			{"Getenv", []interface{}{"LISTEN_PID"}, []interface{}{tc.ListenPid}},
 			{"Getpid", nil, []interface{}{tc.pid}},
 			{"Getenv", []interface{}{"LISTEN_FDS"}, []interface{}{tc.ListenFds}},
-			{"Syscall", []interface{}{uintptr(syscall.SYS_FCNTL), uintptr(3), uintptr(syscall.F_GETFL), uintptr(0)}, []interface{}{tc.GetflFlags, uintptr(0), tc.GetflErr}},
-			{"Syscall", []interface{}{uintptr(syscall.SYS_FCNTL), uintptr(3), uintptr(syscall.F_SETFL), tc.SetflFlags}, []interface{}{uintptr(0), uintptr(0), tc.SetflErr}},
+			{"Syscall", []interface{}{uintptr(syscall.SYS_FCNTL), uintptr(3), uintptr(syscall.F_GETFD), uintptr(0)}, []interface{}{tc.GetfdFlags, uintptr(0), tc.GetfdErr}},
+			{"Syscall", []interface{}{uintptr(syscall.SYS_FCNTL), uintptr(3), uintptr(syscall.F_SETFD), tc.SetfdFlags}, []interface{}{uintptr(0), uintptr(0), tc.SetfdErr}},
 		}
 
 		num_fds, err := listenFds()
@@ -63,15 +66,18 @@ func TestGetFile(t *testing.T) {
 	}{
 		{Num: 1234, FstatErr: 1, Err: true},
 		{Num: 1234, FstatErr: 0, Fstat: syscall.Stat_t{Mode: 0}, Err: true},
-		{Num: 1234, FstatErr: 0, Fstat: syscall.Stat_t{Mode: syscall.S_IFIFO}, Err: false, Outfd: 1237},
-		{Num: 1234, FstatErr: 0, Fstat: syscall.Stat_t{Mode: syscall.S_IFREG}, Err: false, Outfd: 1237},
-		{Num: 1234, FstatErr: 0, Fstat: syscall.Stat_t{Mode: syscall.S_IFCHR}, Err: false, Outfd: 1237},
+		{Num: 1234, FstatErr: 0, Fstat: syscall.Stat_t{Mode: syscall.S_IFIFO}, Err: false, Outfd: 1238},
+		{Num: 1234, FstatErr: 0, Fstat: syscall.Stat_t{Mode: syscall.S_IFREG}, Err: false, Outfd: 1238},
+		{Num: 1234, FstatErr: 0, Fstat: syscall.Stat_t{Mode: syscall.S_IFCHR}, Err: false, Outfd: 1238},
+		{Num: 1234, FstatErr: 0, Fstat: syscall.Stat_t{Mode: syscall.S_IFSOCK}, Err: true},
 	}
 
 	for _, tc := range testcases {
 		osm = &mock{
 			{"Fstat", []interface{}{1237}, []interface{}{tc.Fstat, tc.FstatErr}},
-			{"NewFile", []interface{}{uintptr(1237), ""}, []interface{}{os.NewFile(1237, "")}},
+			{"Dup", []interface{}{1237}, []interface{}{1238, syscall.Errno(0)}},
+			{"NewFile", []interface{}{uintptr(1238), ""}, []interface{}{os.NewFile(1238, "")}},
+			{"Close", []interface{}{1237}, []interface{}{syscall.Errno(0)}},
 		}
 
 		f, err := getFile(tc.Num)
@@ -83,3 +89,224 @@ func TestGetFile(t *testing.T) {
 		}
 	}
 }
+
+func TestListenFdNames(t *testing.T) {
+
+	var testcases = []struct {
+		ListenFdNames string
+		Num           int
+		Names         []string
+		Err           bool
+	}{
+		{ListenFdNames: "", Num: 0, Names: []string{}},
+		{ListenFdNames: "", Num: 2, Names: []string{"unknown", "unknown"}},
+		{ListenFdNames: "http", Num: 1, Names: []string{"http"}},
+		{ListenFdNames: "http:http:control", Num: 3, Names: []string{"http", "http", "control"}},
+		{ListenFdNames: "http:control", Num: 3, Err: true},
+		{ListenFdNames: "http:http:control", Num: 2, Err: true},
+	}
+
+	for _, tc := range testcases {
+		osm = &mock{
+			{"Getenv", []interface{}{"LISTEN_FDNAMES"}, []interface{}{tc.ListenFdNames}},
+		}
+
+		names, err := listenFdNames(tc.Num)
+		if tc.Err != (err != nil) {
+			t.Errorf("listenFdNames(%d) with %q: unexpected error %v", tc.Num, tc.ListenFdNames, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(names, tc.Names) {
+			t.Errorf("listenFdNames(%d) with %q = %q, expected %q", tc.Num, tc.ListenFdNames, names, tc.Names)
+		}
+	}
+}
+
+func TestStoreFds(t *testing.T) {
+	// We don't use a mock, we pass real pipes
+	osm = &osPackage{}
+
+	// The read ends will be owned by the *os.File we store them in. Each pipe
+	// contains its index, so we can check the order they are stored in.
+	pipes := func(n int) (nums []int) {
+		for i := 0; i < n; i++ {
+			var p [2]int
+			if err := syscall.Pipe(p[:]); err != nil {
+				t.Fatal(err)
+			}
+			syscall.Write(p[1], []byte{byte(i)})
+			syscall.Close(p[1])
+			nums = append(nums, p[0]-listenFdsStart)
+		}
+		return nums
+	}
+
+	nums := pipes(3)
+
+	var single *os.File
+	var rest []*os.File
+	n, err := storeFds(nums, []interface{}{&single, &rest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || single == nil || len(rest) != 2 {
+		t.Fatalf("Got n = %d, single = %v, rest = %v", n, single, rest)
+	}
+	index := func(f *os.File) int {
+		b := make([]byte, 1)
+		if _, err := f.Read(b); err != nil {
+			t.Fatal(err)
+		}
+		return int(b[0])
+	}
+	if index(single) != 0 || index(rest[1]) != 2 {
+		t.Errorf("File descriptors stored in wrong order")
+	}
+
+	fixed := make([]*os.File, 2)
+	if _, err = storeFds(pipes(3), []interface{}{fixed}); err == nil {
+		t.Errorf("Expected error for too few targets")
+	}
+
+	var wrong int
+	if _, err = storeFds(pipes(1), []interface{}{wrong}); err == nil {
+		t.Errorf("Expected error for unhandled target type")
+	}
+}
+
+func TestStoreFdSocketTypes(t *testing.T) {
+	osm = &osPackage{}
+
+	// store places the file descriptor of f in target. f is consumed.
+	store := func(f *os.File, target interface{}) error {
+		fd, err := syscall.Dup(int(f.Fd()))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storeFd(fd-listenFdsStart, reflect.ValueOf(target).Elem())
+	}
+
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	var pc net.PacketConn
+	if err = store(rawFd(t, uc), &pc); err != nil {
+		t.Errorf("Storing UDP socket in net.PacketConn: %v", err)
+	} else {
+		pc.Close()
+	}
+
+	path := filepath.Join(t.TempDir(), "seqpacket")
+	sl, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sl.Close()
+	var ul *net.UnixListener
+	if err = store(rawFd(t, sl), &ul); err != nil {
+		t.Errorf("Storing seqpacket socket in *net.UnixListener: %v", err)
+	} else {
+		if ul.Addr().Network() != "unixpacket" {
+			t.Errorf("Got network %q, expected unixpacket", ul.Addr().Network())
+		}
+		ul.Close()
+	}
+}
+
+// setPassed pretends that n file descriptors have been passed.
+func setPassed(n int) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	passed = make([]string, n)
+	for i := range passed {
+		passed[i] = "unknown"
+	}
+	passedErr = nil
+	passedRead = true
+	claimed = make([]bool, n)
+}
+
+func TestClaim(t *testing.T) {
+	setPassed(3)
+	defer setPassed(0)
+
+	if err := claim(1); err != nil {
+		t.Errorf("First claim failed: %v", err)
+	}
+	if err := claim(1); err == nil {
+		t.Errorf("Second claim succeeded")
+	}
+	if err := claim(42); err != nil {
+		t.Errorf("Claim outside of passed file descriptors failed: %v", err)
+	}
+
+	fds, err := UnclaimedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fds, []int{3, 5}) {
+		t.Errorf("UnclaimedFiles() = %v, expected [3 5]", fds)
+	}
+
+	// A failing type check must not claim the file descriptor
+	osm = &osPackage{}
+	uc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	// Use a raw file descriptor, as adopting it closes it.
+	uf := rawFd(t, uc)
+	fd, err := syscall.Dup(int(uf.Fd()))
+	uf.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	num := fd - listenFdsStart
+	setPassed(num + 1)
+
+	if _, err = getTCPConn(num); err == nil {
+		t.Errorf("Expected error for UDP socket in getTCPConn")
+	}
+	if _, err = getTCPListener(num); err == nil {
+		t.Errorf("Expected error for UDP socket in getTCPListener")
+	}
+	c, err := getUDPConn(num)
+	if err != nil {
+		t.Fatalf("getUDPConn failed after failed type checks: %v", err)
+	}
+	defer c.Close()
+	if c.LocalAddr().String() != uc.LocalAddr().String() {
+		t.Errorf("Got socket bound to %v, expected %v", c.LocalAddr(), uc.LocalAddr())
+	}
+	if _, err = getUDPConn(num); err == nil {
+		t.Errorf("Expected error for already claimed file descriptor")
+	}
+	// The original file descriptor was closed when it was adopted.
+	if _, err = syscall.Getsockname(num + listenFdsStart); err != syscall.EBADF {
+		t.Errorf("Adopted file descriptor still open: %v", err)
+	}
+
+	setPassed(3)
+	osm = &mock{
+		{"Fstat", []interface{}{3}, []interface{}{syscall.Stat_t{Mode: syscall.S_IFSOCK}, syscall.Errno(0)}},
+		{"Fstat", []interface{}{3}, []interface{}{syscall.Stat_t{Mode: syscall.S_IFIFO}, syscall.Errno(0)}},
+		{"Dup", []interface{}{3}, []interface{}{1003, syscall.Errno(0)}},
+		{"NewFile", []interface{}{uintptr(1003), ""}, []interface{}{os.NewFile(1003, "")}},
+		{"Close", []interface{}{3}, []interface{}{syscall.Errno(0)}},
+		{"Fstat", []interface{}{3}, []interface{}{syscall.Stat_t{Mode: syscall.S_IFIFO}, syscall.Errno(0)}},
+	}
+	if _, err = getFile(0); err == nil {
+		t.Errorf("Expected error for socket in getFile")
+	}
+	if _, err = getFile(0); err != nil {
+		t.Errorf("getFile failed after failed type check: %v", err)
+	}
+	if _, err = getFile(0); err == nil {
+		t.Errorf("Expected error for already claimed file descriptor")
+	}
+}
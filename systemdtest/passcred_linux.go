@@ -0,0 +1,26 @@
+package systemdtest
+
+import (
+	"net"
+	"syscall"
+)
+
+// credSpace is the space needed to receive the credentials of the sender.
+var credSpace = syscall.CmsgSpace(syscall.SizeofUcred)
+
+// setPassCred enables receiving the credentials of the sender.
+func setPassCred(conn *net.UnixConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package systemdtest
+
+import "net"
+
+// credSpace is zero, as credentials are only received on Linux.
+const credSpace = 0
+
+// setPassCred does nothing, as credentials are only received on Linux.
+func setPassCred(conn *net.UnixConn) error {
+	return nil
+}
@@ -0,0 +1,281 @@
+// Package systemdtest implements a fake service manager, that can be used to
+// test programs using the notification and watchdog protocols of package
+// systemd.
+//
+// A test for a daemon, that should signal readiness, might look like this:
+//
+//		func TestReady(t *testing.T) {
+//			s, err := systemdtest.NewServer()
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			defer s.Close()
+//
+//			go runDaemon()
+//
+//			if _, err := s.WaitFor("READY=1", time.Second); err != nil {
+//				t.Fatal(err)
+//			}
+//		}
+package systemdtest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Merovius/systemd"
+)
+
+// Message is a notification received by a Server.
+type Message struct {
+	// Raw is the message as received.
+	Raw string
+
+	// Err is the error returned by systemd.ParseMessage, if the message is
+	// not valid. The fields of invalid messages are still recorded, as far
+	// as they could be parsed.
+	Err error
+
+	// Message is the parsed message. The passed files are closed by
+	// Server.Close. On Linux, the credentials of the sender are always
+	// included.
+	systemd.Message
+}
+
+// record is a received message and whether WaitFor returned it already.
+type record struct {
+	msg      *Message
+	returned bool
+}
+
+// Server is a fake notification socket. While it exists, NOTIFY_SOCKET
+// points to it and the package-level Notify* functions of package systemd
+// send their notifications to it.
+type Server struct {
+	conn *net.UnixConn
+	dir  string
+	addr string
+
+	mtx     sync.Mutex
+	msgs    []*record
+	changed chan struct{}
+	err     error
+	done    chan struct{}
+
+	oldEnv map[string]*string
+}
+
+// NewServer creates a notification socket in a temporary directory and sets
+// NOTIFY_SOCKET to point to it. The previous environment is restored by
+// Close.
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "systemdtest")
+	if err != nil {
+		return nil, err
+	}
+
+	addr := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err = setPassCred(conn); err != nil {
+		conn.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s := &Server{
+		conn:    conn,
+		dir:     dir,
+		addr:    addr,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+		oldEnv:  make(map[string]*string),
+	}
+	s.setenv("NOTIFY_SOCKET", addr)
+	systemd.ResetDefaultNotifier()
+
+	go s.receive()
+	return s, nil
+}
+
+// setenv sets an environment variable, remembering the old value.
+func (s *Server) setenv(key, val string) {
+	if _, ok := s.oldEnv[key]; !ok {
+		if old, ok := os.LookupEnv(key); ok {
+			s.oldEnv[key] = &old
+		} else {
+			s.oldEnv[key] = nil
+		}
+	}
+	os.Setenv(key, val)
+}
+
+// Addr returns the address of the notification socket.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Notifier returns a Notifier, that sends its notifications to s. Unlike the
+// package-level Notify* functions, it does not depend on NOTIFY_SOCKET.
+func (s *Server) Notifier() *systemd.Notifier {
+	// s.addr is an absolute path, so it is always valid.
+	n, _ := systemd.NewNotifier(s.addr)
+	return n
+}
+
+// Env returns the environment variables for a child process, that should
+// send its notifications to s, e.g. for use in exec.Cmd.Env.
+func (s *Server) Env() []string {
+	return []string{"NOTIFY_SOCKET=" + s.addr}
+}
+
+// SetWatchdog enables the watchdog for the current process with the given
+// timeout, by setting WATCHDOG_PID and WATCHDOG_USEC.
+func (s *Server) SetWatchdog(timeout time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	s.setenv("WATCHDOG_USEC", strconv.FormatInt(int64(timeout/time.Microsecond), 10))
+}
+
+// receive reads messages from the socket, until it is closed.
+func (s *Server) receive() {
+	defer close(s.done)
+
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(253*4)+credSpace)
+
+	for {
+		n, oobn, _, _, err := s.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			s.mtx.Lock()
+			s.err = err
+			s.mtx.Unlock()
+			return
+		}
+
+		msg := parseMessage(buf[:n], oob[:oobn])
+
+		// The barrier is passed, once we close the pipe.
+		if v, _ := msg.Get("BARRIER"); v == "1" {
+			for _, f := range msg.Files {
+				f.Close()
+			}
+			msg.Files = nil
+		}
+
+		s.mtx.Lock()
+		s.msgs = append(s.msgs, &record{msg: msg})
+		close(s.changed)
+		s.changed = make(chan struct{})
+		s.mtx.Unlock()
+	}
+}
+
+// parseMessage parses a received notification.
+func parseMessage(b, oob []byte) *Message {
+	m, err := systemd.ParseMessage(b, oob)
+	if m == nil {
+		m = new(systemd.Message)
+	}
+	return &Message{Raw: string(b), Err: err, Message: *m}
+}
+
+// Messages returns all messages received so far.
+func (s *Server) Messages() []*Message {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	msgs := make([]*Message, len(s.msgs))
+	for i, r := range s.msgs {
+		msgs[i] = r.msg
+	}
+	return msgs
+}
+
+// matches returns whether msg contains state, which is either a KEY=VALUE
+// assignment or only a KEY, which matches any value.
+func matches(msg *Message, state string) bool {
+	i := strings.IndexByte(state, '=')
+	if i < 0 {
+		_, ok := msg.Get(state)
+		return ok
+	}
+	v, ok := msg.Get(state[:i])
+	return ok && v == state[i+1:]
+}
+
+// WaitFor waits until a message containing state was received and returns
+// it. state is either a KEY=VALUE assignment (e.g. "READY=1") or only a KEY
+// (e.g. "STATUS"), which matches any value. Every message is only returned by
+// one call of WaitFor, so e.g. watchdog pings can be counted by calling
+// WaitFor("WATCHDOG=1", timeout) repeatedly.
+func (s *Server) WaitFor(state string, timeout time.Duration) (*Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		s.mtx.Lock()
+		for _, r := range s.msgs {
+			if !r.returned && matches(r.msg, state) {
+				r.returned = true
+				s.mtx.Unlock()
+				return r.msg, nil
+			}
+		}
+		changed, err := s.changed, s.err
+		s.mtx.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return nil, fmt.Errorf("Timeout waiting for %q", state)
+		}
+	}
+}
+
+// Close closes the socket and the received files and restores the
+// environment.
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	<-s.done
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, r := range s.msgs {
+		for _, f := range r.msg.Files {
+			f.Close()
+		}
+	}
+
+	for k, v := range s.oldEnv {
+		if v == nil {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, *v)
+		}
+	}
+	systemd.ResetDefaultNotifier()
+
+	if rerr := os.RemoveAll(s.dir); err == nil {
+		err = rerr
+	}
+	return err
+}
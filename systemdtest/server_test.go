@@ -0,0 +1,178 @@
+package systemdtest
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Merovius/systemd"
+)
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	old, hadOld := os.LookupEnv("NOTIFY_SOCKET")
+
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := os.Getenv("NOTIFY_SOCKET"); got != s.Addr() {
+		t.Fatalf("NOTIFY_SOCKET = %q, expected %q", got, s.Addr())
+	}
+	if !strings.HasPrefix(s.Addr(), dir) {
+		t.Errorf("Socket %q not created in %q", s.Addr(), dir)
+	}
+
+	if err = systemd.NotifyStatus("starting"); err != nil {
+		t.Fatal(err)
+	}
+	if err = systemd.NotifyReady(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = systemd.NotifyWatchdog(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := s.WaitFor("READY=1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Credentials == nil || int(m.Credentials.Pid) != os.Getpid() {
+		t.Errorf("Credentials = %+v, expected pid %d", m.Credentials, os.Getpid())
+	}
+
+	m, err = s.WaitFor("STATUS", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get("STATUS"); v != "starting" {
+		t.Errorf("STATUS = %q, expected %q", v, "starting")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = s.WaitFor("WATCHDOG=1", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = s.WaitFor("WATCHDOG=1", 10*time.Millisecond); err == nil {
+		t.Error("WaitFor returned the same message twice")
+	}
+
+	if n := len(s.Messages()); n != 4 {
+		t.Errorf("Got %d messages, expected 4", n)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := os.LookupEnv("NOTIFY_SOCKET"); ok != hadOld || got != old {
+		t.Errorf("NOTIFY_SOCKET not restored, got %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Temporary directory not removed")
+	}
+}
+
+func TestServerReset(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		s, err := NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = systemd.NotifyReady(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = s.WaitFor("READY=1", time.Second); err != nil {
+			t.Errorf("Server %d: %v", i, err)
+		}
+		s.Close()
+	}
+}
+
+func TestServerNotifier(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	os.Unsetenv("NOTIFY_SOCKET")
+
+	n := s.Notifier()
+	defer n.Close()
+	if err = n.Notify(context.Background(), "STATUS=notifier"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.WaitFor("STATUS", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get("STATUS"); v != "notifier" {
+		t.Errorf("STATUS = %q, expected %q", v, "notifier")
+	}
+}
+
+func TestServerFiles(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = systemd.NotifyBarrier(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	if err = systemd.StoreFiles("pipe", r); err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.WaitFor("FDSTORE=1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get("FDNAME"); v != "pipe" || len(m.Files) != 1 {
+		t.Fatalf("Got %q with %d files", m.Raw, len(m.Files))
+	}
+
+	w.Write([]byte("x"))
+	var buf [1]byte
+	if _, err = m.Files[0].Read(buf[:]); err != nil || buf[0] != 'x' {
+		t.Errorf("Reading passed file: %q, %v", buf[:], err)
+	}
+}
+
+func TestServerWatchdog(t *testing.T) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.SetWatchdog(2 * time.Second)
+	if got := os.Getenv("WATCHDOG_USEC"); got != "2000000" {
+		t.Errorf("WATCHDOG_USEC = %q, expected 2000000", got)
+	}
+	if got := os.Getenv("WATCHDOG_PID"); got != strconv.Itoa(os.Getpid()) {
+		t.Errorf("WATCHDOG_PID = %q, expected %d", got, os.Getpid())
+	}
+
+	active, d, err := systemd.IsWatchdogActive()
+	if !active || err != nil || d != 2*time.Second {
+		t.Errorf("IsWatchdogActive() = %v, %v, %v, expected true, 2s, <nil>", active, d, err)
+	}
+}
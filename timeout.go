@@ -0,0 +1,61 @@
+package systemd
+
+import (
+	"context"
+	"time"
+)
+
+// NotifyExtendTimeout asks the system manager to extend the current startup,
+// runtime or shutdown timeout, so that it expires d from now. It must be
+// called again before that, if the operation takes longer.
+func NotifyExtendTimeout(d time.Duration) error {
+	return NewNotification().ExtendTimeout(d).Send()
+}
+
+// ExtendTimeoutWhile runs task and keeps extending the current timeout by
+// extend, until it returns. This is useful for long running operations during
+// startup or shutdown, e.g. database migrations, that would otherwise exceed
+// TimeoutStartSec= or TimeoutStopSec=. The timeout is extended every half of
+// extend, so it should be well above the latency of the system manager.
+//
+// The context passed to task is cancelled if ctx is. The error of task is
+// returned. If task succeeds, but the timeout could not be extended, that
+// error is returned instead; not being run by systemd (ErrNoNotifySocket) is
+// not considered an error.
+func ExtendTimeoutWhile(ctx context.Context, extend time.Duration, task func(context.Context) error) error {
+	if err := NewNotification().ExtendTimeout(extend).Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- task(ctx)
+	}()
+
+	var notifyErr error
+	extendTimeout := func() {
+		err := NotifyExtendTimeout(extend)
+		if err != nil && err != ErrNoNotifySocket && notifyErr == nil {
+			notifyErr = err
+		}
+	}
+
+	extendTimeout()
+	tick := time.NewTicker(extend / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			return notifyErr
+		case <-tick.C:
+			extendTimeout()
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExtendTimeoutWhile(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- ExtendTimeoutWhile(context.Background(), 20*time.Millisecond, func(ctx context.Context) error {
+			<-release
+			return nil
+		})
+	}()
+
+	// The first extension is sent immediately, then every 10ms
+	for i := 0; i < 3; i++ {
+		if msg, _ := readNotify(t, conn); msg != "EXTEND_TIMEOUT_USEC=20000" {
+			t.Errorf("Got %q", msg)
+		}
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("ExtendTimeoutWhile returned %v", err)
+	}
+}
+
+func TestExtendTimeoutWhileErrors(t *testing.T) {
+	_, cleanup := fakeNotifySocket(t)
+	cleanup()
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	taskErr := errors.New("task failed")
+
+	err := ExtendTimeoutWhile(context.Background(), time.Second, func(ctx context.Context) error {
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Got %v without notification socket, expected nil", err)
+	}
+
+	err = ExtendTimeoutWhile(context.Background(), time.Second, func(ctx context.Context) error {
+		return taskErr
+	})
+	if err != taskErr {
+		t.Errorf("Got %v, expected %v", err, taskErr)
+	}
+
+	err = ExtendTimeoutWhile(context.Background(), 0, func(ctx context.Context) error {
+		t.Errorf("Task was run with invalid timeout")
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Expected error for invalid timeout")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ExtendTimeoutWhile(ctx, time.Second, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Errorf("Got %v, expected %v", err, context.Canceled)
+	}
+}
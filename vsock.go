@@ -0,0 +1,78 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// vsockAddr is the address of a vsock socket.
+type vsockAddr struct {
+	cid  uint32
+	port uint32
+}
+
+func (a vsockAddr) Network() string {
+	return "vsock"
+}
+
+func (a vsockAddr) String() string {
+	return fmt.Sprintf("%d:%d", a.cid, a.port)
+}
+
+// parseVsockAddr parses a notification socket address of the form
+// vsock:CID:PORT, vsock-stream:CID:PORT, vsock-dgram:CID:PORT or
+// vsock-seqpacket:CID:PORT. ok is false, if addr is not a vsock address. An
+// sotype of 0 means, that the type was not specified.
+func parseVsockAddr(addr string) (a vsockAddr, sotype int, ok bool, err error) {
+	i := strings.IndexByte(addr, ':')
+	if i < 0 {
+		return a, 0, false, nil
+	}
+
+	switch addr[:i] {
+	case "vsock":
+		sotype = 0
+	case "vsock-stream":
+		sotype = syscall.SOCK_STREAM
+	case "vsock-dgram":
+		sotype = syscall.SOCK_DGRAM
+	case "vsock-seqpacket":
+		sotype = syscall.SOCK_SEQPACKET
+	default:
+		return a, 0, false, nil
+	}
+
+	parts := strings.Split(addr[i+1:], ":")
+	if len(parts) != 2 {
+		return a, 0, true, fmt.Errorf("Invalid vsock address %q", addr)
+	}
+	cid, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return a, 0, true, fmt.Errorf("Invalid CID in vsock address %q", addr)
+	}
+	port, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return a, 0, true, fmt.Errorf("Invalid port in vsock address %q", addr)
+	}
+
+	return vsockAddr{uint32(cid), uint32(port)}, sotype, true, nil
+}
+
+// vsockConn is a connected vsock socket. The net package does not support
+// vsock, so it is built on *os.File.
+type vsockConn struct {
+	*os.File
+	remote vsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr {
+	return vsockAddr{}
+}
+
+func (c *vsockConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,14 @@
+//go:build linux && !386
+
+package systemd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// rawConnect calls connect(2) with a sockaddr unknown to package syscall.
+func rawConnect(fd int, sa unsafe.Pointer, n uintptr) syscall.Errno {
+	_, _, errno := syscall.Syscall(syscall.SYS_CONNECT, uintptr(fd), uintptr(sa), n)
+	return errno
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package systemd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// sysConnect is the number of connect in socketcall(2).
+const sysConnect = 3
+
+// rawConnect calls connect(2) with a sockaddr unknown to package syscall. On
+// 386, the socket calls are multiplexed via socketcall(2).
+func rawConnect(fd int, sa unsafe.Pointer, n uintptr) syscall.Errno {
+	args := [3]uintptr{uintptr(fd), uintptr(sa), n}
+	_, _, errno := syscall.Syscall(syscall.SYS_SOCKETCALL, sysConnect, uintptr(unsafe.Pointer(&args)), 0)
+	return errno
+}
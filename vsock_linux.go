@@ -0,0 +1,54 @@
+package systemd
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// afVsock is AF_VSOCK, which the syscall package does not define.
+const afVsock = 40
+
+// rawSockaddrVM corresponds to struct sockaddr_vm.
+type rawSockaddrVM struct {
+	Family    uint16
+	Reserved1 uint16
+	Port      uint32
+	Cid       uint32
+	Flags     uint8
+	Zero      [3]uint8
+}
+
+// dialVsock connects to addr with a socket of the given type. If sotype is 0,
+// a datagram socket is tried first, falling back to SOCK_SEQPACKET if the
+// transport does not support datagrams.
+func dialVsock(addr vsockAddr, sotype int) (*vsockConn, error) {
+	if sotype == 0 {
+		c, err := dialVsock(addr, syscall.SOCK_DGRAM)
+		if err == syscall.ESOCKTNOSUPPORT || err == syscall.EPROTONOSUPPORT || err == syscall.EOPNOTSUPP {
+			return dialVsock(addr, syscall.SOCK_SEQPACKET)
+		}
+		return c, err
+	}
+
+	fd, err := syscall.Socket(afVsock, sotype|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sa := &rawSockaddrVM{Family: afVsock, Port: addr.port, Cid: addr.cid}
+	errno := rawConnect(fd, unsafe.Pointer(sa), unsafe.Sizeof(*sa))
+	runtime.KeepAlive(sa)
+	if errno != 0 {
+		syscall.Close(fd)
+		return nil, errno
+	}
+
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return &vsockConn{os.NewFile(uintptr(fd), "vsock:"+addr.String()), addr}, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package systemd
+
+// dialVsock returns an error, as vsock is only supported on Linux.
+func dialVsock(addr vsockAddr, sotype int) (*vsockConn, error) {
+	return nil, errUnsupported
+}
@@ -0,0 +1,61 @@
+package systemd
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseVsockAddr(t *testing.T) {
+	var testcases = []struct {
+		Addr   string
+		Out    vsockAddr
+		Sotype int
+		Ok     bool
+		Err    bool
+	}{
+		{Addr: "/run/systemd/notify"},
+		{Addr: "@abstract:with:colons"},
+		{Addr: "vsock:2:1234", Out: vsockAddr{2, 1234}, Ok: true},
+		{Addr: "vsock-stream:2:1234", Out: vsockAddr{2, 1234}, Sotype: syscall.SOCK_STREAM, Ok: true},
+		{Addr: "vsock-dgram:3:1", Out: vsockAddr{3, 1}, Sotype: syscall.SOCK_DGRAM, Ok: true},
+		{Addr: "vsock-seqpacket:4294967295:4294967295", Out: vsockAddr{4294967295, 4294967295}, Sotype: syscall.SOCK_SEQPACKET, Ok: true},
+		{Addr: "vsock:2", Ok: true, Err: true},
+		{Addr: "vsock:2:3:4", Ok: true, Err: true},
+		{Addr: "vsock:host:1234", Ok: true, Err: true},
+		{Addr: "vsock:2:-1", Ok: true, Err: true},
+		{Addr: "vsock-stream:2:4294967296", Ok: true, Err: true},
+	}
+
+	for _, tc := range testcases {
+		out, sotype, ok, err := parseVsockAddr(tc.Addr)
+		if ok != tc.Ok || tc.Err != (err != nil) {
+			t.Errorf("parseVsockAddr(%q) = %v, %v, expected %v, error %v", tc.Addr, ok, err, tc.Ok, tc.Err)
+			continue
+		}
+		if ok && err == nil && (out != tc.Out || sotype != tc.Sotype) {
+			t.Errorf("parseVsockAddr(%q) = %v, %d, expected %v, %d", tc.Addr, out, sotype, tc.Out, tc.Sotype)
+		}
+	}
+}
+
+func TestCheckNotifyAddr(t *testing.T) {
+	var testcases = []struct {
+		Addr string
+		Err  bool
+	}{
+		{"/run/systemd/notify", false},
+		{"@abstract", false},
+		{"vsock:2:1234", false},
+		{"vsock-stream:1:1", false},
+		{"", true},
+		{"relative", true},
+		{"vsock:2", true},
+		{"tcp:1.2.3.4:5", true},
+	}
+
+	for _, tc := range testcases {
+		if err := checkNotifyAddr(tc.Addr); tc.Err != (err != nil) {
+			t.Errorf("checkNotifyAddr(%q): unexpected error %v", tc.Addr, err)
+		}
+	}
+}
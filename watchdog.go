@@ -1,8 +1,13 @@
 package systemd
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,6 +17,8 @@ import (
 // process. It is recommended to generate keep-alive pings every half of the
 // returned time.
 func IsWatchdogActive() (bool, time.Duration, error) {
+	defer consumeEnv(Watchdog)
+
 	// Get PID that is watched
 	e := osm.Getenv("WATCHDOG_PID")
 	if e == "" {
@@ -49,28 +56,434 @@ func IsWatchdogActive() (bool, time.Duration, error) {
 // keep-alive ping at the recommended interval in a seperate goroutine. It
 // returns, whether a watchdog is active, the intervals at which a ping will be
 // sent and whether an error occured during the initialization. Any errors
-// occuring when pinging will be ignored.
+// occuring when pinging will be ignored and the pings can not be stopped; use
+// StartWatchdog for more control.
 func AutoWatchdog() (bool, time.Duration, error) {
-	active, interval, err := IsWatchdogActive()
-	if !active || err != nil {
-		return active, 0, err
+	w, err := StartWatchdog(context.Background(), nil)
+	if w == nil || err != nil {
+		return false, 0, err
+	}
+	return true, w.Interval(), nil
+}
+
+// WatchdogOptions configures the pings sent by StartWatchdog.
+type WatchdogOptions struct {
+	// Interval is the time between two pings. If it is zero, half of the
+	// timeout returned by IsWatchdogActive is used.
+	Interval time.Duration
+
+	// Jitter randomizes the time between two pings to be between
+	// Interval-Jitter and Interval, so that many services started at the
+	// same time do not ping in lockstep. It must be less than Interval.
+	Jitter time.Duration
+
+	// OnError is called with the error, if sending a ping fails. It is
+	// called from the goroutine sending the pings, so it should not block.
+	OnError func(error)
+
+	// Notifier is used to send the pings. If it is nil, the Notifier used
+	// by the package-level Notify* functions is used.
+	Notifier *Notifier
+
+	// HealthChecks are run before every ping, except the initial one sent
+	// by StartWatchdog. If one of them fails, no ping is sent, so the
+	// system manager acts on the service if it stays unhealthy for the
+	// watchdog timeout, and STATUS= is set to the first error, until the
+	// service is healthy again and it is cleared. The errors of all failed
+	// checks are passed to OnError.
+	HealthChecks []HealthCheck
+
+	// Trigger makes a failed health check or a stalled Heartbeat send
+	// WATCHDOG=trigger, so that the system manager acts on the service
+	// immediately, instead of waiting for the timeout.
+	Trigger bool
+
+	// After waits for the given duration and then sends the current time,
+	// like time.After, which is used if it is nil. It can be set to drive
+	// the pings from a fake clock in tests.
+	After func(time.Duration) <-chan time.Time
+
+	// Now returns the current time, like time.Now, which is used if it is
+	// nil. Heartbeats use it to decide whether they are stalled.
+	Now func() time.Time
+}
+
+// HealthCheck is a check of the health of the service, that is run before
+// sending a watchdog ping.
+type HealthCheck struct {
+	// Name describes the check in errors.
+	Name string
+
+	// Check returns an error, if the service is not healthy. The passed
+	// context is cancelled after Timeout.
+	Check func(ctx context.Context) error
+
+	// Timeout is the time the check may take, before it counts as failed.
+	// If it is zero, a quarter of the interval between two pings is used,
+	// so that a slow check does not delay the ping past the watchdog
+	// timeout. A check that does not return in time is left running in the
+	// background.
+	Timeout time.Duration
+}
+
+// HealthCheckError is passed to WatchdogOptions.OnError, if a health check
+// fails.
+type HealthCheckError struct {
+	Name string
+	Err  error
+}
+
+func (e *HealthCheckError) Error() string {
+	return fmt.Sprintf("Health check %s failed: %v", e.Name, e.Err)
+}
+
+// WatchdogPinger sends watchdog keep-alive pings in the background. It is
+// created by StartWatchdog.
+type WatchdogPinger struct {
+	opts   WatchdogOptions
+	cancel context.CancelFunc
+	done   chan struct{}
+	reset  chan struct{}
+
+	// failing is set while STATUS= reports a failure. It is only accessed
+	// by the goroutine sending the pings.
+	failing bool
+
+	mtx        sync.Mutex
+	interval   time.Duration
+	heartbeats []*Heartbeat
+}
+
+// envPingers are the running WatchdogPingers, that use the timeout from the
+// environment and the default Notifier. They are updated by
+// NotifyWatchdogUsec.
+var envPingers = struct {
+	sync.Mutex
+	m map[*WatchdogPinger]bool
+}{m: make(map[*WatchdogPinger]bool)}
+
+// StartWatchdog sends a keep-alive ping and then starts sending them
+// regularly in a separate goroutine, until ctx is done or Stop is called. If
+// the watchdog is not active (see IsWatchdogActive) and no Interval is given
+// in opts, which may be nil, it returns a nil *WatchdogPinger and no error.
+func StartWatchdog(ctx context.Context, opts *WatchdogOptions) (*WatchdogPinger, error) {
+	w := &WatchdogPinger{
+		done:  make(chan struct{}),
+		reset: make(chan struct{}, 1),
+	}
+	if opts != nil {
+		w.opts = *opts
+	}
+	fromEnv := w.opts.Interval == 0 && w.opts.Notifier == nil
+	if w.opts.Notifier == nil {
+		w.opts.Notifier = defaultNotifier
+	}
+	if w.opts.After == nil {
+		w.opts.After = time.After
+	}
+	if w.opts.Now == nil {
+		w.opts.Now = time.Now
 	}
 
-	interval = interval / 2
+	w.interval = w.opts.Interval
+	if w.interval == 0 {
+		active, timeout, err := IsWatchdogActive()
+		if !active || err != nil {
+			return nil, err
+		}
+		w.interval = timeout / 2
+	}
+	if w.interval <= 0 {
+		return nil, fmt.Errorf("Invalid interval %v", w.interval)
+	}
+	if w.opts.Jitter < 0 || w.opts.Jitter >= w.interval {
+		return nil, fmt.Errorf("Jitter %v must be between 0 and the interval %v", w.opts.Jitter, w.interval)
+	}
+	for _, c := range w.opts.HealthChecks {
+		if c.Check == nil || c.Timeout < 0 {
+			return nil, fmt.Errorf("Invalid health check %q", c.Name)
+		}
+	}
+
+	ctx, w.cancel = context.WithCancel(ctx)
 
 	// For good measure we immediately send a ping. This will also catch
 	// most connection issues.
-	err = NotifyWatchdog()
+	if err := w.ping(ctx); err != nil {
+		w.cancel()
+		return nil, err
+	}
+
+	if fromEnv {
+		envPingers.Lock()
+		envPingers.m[w] = true
+		envPingers.Unlock()
+	}
+
+	go w.run(ctx)
+	return w, nil
+}
+
+// period returns the time between two pings without jitter.
+func (w *WatchdogPinger) period() time.Duration {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.interval
+}
+
+// Interval returns the average time between two pings.
+func (w *WatchdogPinger) Interval() time.Duration {
+	iv := w.period()
+	if w.opts.Jitter >= iv {
+		return iv
+	}
+	return iv - w.opts.Jitter/2
+}
+
+// next returns the time until the next ping. The jitter is ignored, if the
+// interval was made shorter than it with SetTimeout.
+func (w *WatchdogPinger) next() time.Duration {
+	iv := w.period()
+	if w.opts.Jitter == 0 || w.opts.Jitter >= iv {
+		return iv
+	}
+	return iv - time.Duration(rand.Int63n(int64(w.opts.Jitter)))
+}
+
+// SetTimeout changes the watchdog timeout of the service to d, by sending
+// WATCHDOG_USEC= (see NotifyWatchdogUsec), and sets the interval between two
+// pings to half of it. A ping is sent immediately, so that the new timeout
+// starts counting from now.
+func (w *WatchdogPinger) SetTimeout(ctx context.Context, d time.Duration) error {
+	if err := NewNotification().WatchdogUsec(d).SendTo(ctx, w.opts.Notifier); err != nil {
+		return err
+	}
+	w.setInterval(d / 2)
+	return nil
+}
+
+// setInterval changes the interval between two pings and makes the running
+// goroutine ping immediately.
+func (w *WatchdogPinger) setInterval(iv time.Duration) {
+	w.mtx.Lock()
+	w.interval = iv
+	w.mtx.Unlock()
+
+	select {
+	case w.reset <- struct{}{}:
+	default:
+	}
+}
+
+// ping sends a single keep-alive ping.
+func (w *WatchdogPinger) ping(ctx context.Context) error {
+	return w.opts.Notifier.Notify(ctx, "WATCHDOG=1")
+}
+
+// check runs all health checks concurrently and returns the errors of those
+// that failed.
+func (w *WatchdogPinger) check(ctx context.Context) []error {
+	errs := make([]chan error, len(w.opts.HealthChecks))
+	for i, c := range w.opts.HealthChecks {
+		errs[i] = make(chan error, 1)
+		go func(c HealthCheck, ch chan error) {
+			ch <- w.runCheck(ctx, c)
+		}(c, errs[i])
+	}
+
+	var failed []error
+	for _, ch := range errs {
+		if err := <-ch; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// runCheck runs a single health check, limited by its timeout.
+func (w *WatchdogPinger) runCheck(ctx context.Context, c HealthCheck) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = w.period() / 4
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res := make(chan error, 1)
+	go func() {
+		res <- c.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-res:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
 	if err != nil {
-		return false, 0, err
+		return &HealthCheckError{c.Name, err}
 	}
+	return nil
+}
+
+// fail tells the system manager that the service is unhealthy because of
+// err, instead of sending a ping.
+func (w *WatchdogPinger) fail(ctx context.Context, err error) error {
+	status := strings.Replace(err.Error(), "\n", " ", -1)
+	m := &Message{Fields: []Field{{"STATUS", status}}}
+	if w.opts.Trigger {
+		m.Fields = append(m.Fields, Field{"WATCHDOG", "trigger"})
+	}
+	return w.opts.Notifier.Send(ctx, m)
+}
+
+// Heartbeat tracks the liveness of a long-running goroutine, e.g. a worker
+// loop. It is created by WatchdogPinger.Heartbeat.
+type Heartbeat struct {
+	// last is accessed atomically and must be 64-bit aligned.
+	last     int64
+	w        *WatchdogPinger
+	name     string
+	deadline time.Duration
+}
+
+// StalledError is passed to WatchdogOptions.OnError, if a Heartbeat was not
+// ticked within its deadline.
+type StalledError struct {
+	Name  string
+	Since time.Duration
+}
+
+func (e *StalledError) Error() string {
+	return fmt.Sprintf("%s stalled, last heartbeat %v ago", e.Name, e.Since)
+}
+
+// Heartbeat registers a goroutine under the given name, that must call Tick
+// on the returned Heartbeat at least once per deadline. If the deadline is
+// zero, the interval between two pings is used. Pings are only sent, if all
+// registered heartbeats are fresh; otherwise STATUS= is set to the name of
+// the stalled goroutine, like for a failed health check. When the goroutine
+// exits, it should call Stop.
+func (w *WatchdogPinger) Heartbeat(name string, deadline time.Duration) *Heartbeat {
+	h := &Heartbeat{w: w, name: name, deadline: deadline}
+	h.Tick()
+
+	w.mtx.Lock()
+	w.heartbeats = append(w.heartbeats, h)
+	w.mtx.Unlock()
+	return h
+}
+
+// Tick marks the goroutine as alive.
+func (h *Heartbeat) Tick() {
+	atomic.StoreInt64(&h.last, h.w.opts.Now().UnixNano())
+}
 
-	go func(interval time.Duration) {
-		tick := time.Tick(interval)
-		for _ = range tick {
-			NotifyWatchdog()
+// Stop removes the Heartbeat from its WatchdogPinger.
+func (h *Heartbeat) Stop() {
+	h.w.mtx.Lock()
+	defer h.w.mtx.Unlock()
+
+	for i, o := range h.w.heartbeats {
+		if o == h {
+			h.w.heartbeats = append(h.w.heartbeats[:i], h.w.heartbeats[i+1:]...)
+			return
+		}
+	}
+}
+
+// stalled returns errors for all heartbeats, that were not ticked within
+// their deadline.
+func (w *WatchdogPinger) stalled() []error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	var errs []error
+	now := w.opts.Now()
+	for _, h := range w.heartbeats {
+		deadline := h.deadline
+		if deadline == 0 {
+			deadline = w.interval
+		}
+		since := now.Sub(time.Unix(0, atomic.LoadInt64(&h.last)))
+		if since > deadline {
+			errs = append(errs, &StalledError{h.name, since})
+		}
+	}
+	return errs
+}
+
+// recovered sends a ping and clears the STATUS= set by fail.
+func (w *WatchdogPinger) recovered(ctx context.Context) error {
+	m := &Message{Fields: []Field{{"STATUS", ""}, {"WATCHDOG", "1"}}}
+	return w.opts.Notifier.Send(ctx, m)
+}
+
+// tick sends a ping, if all heartbeats are fresh and all health checks pass.
+// It returns the errors of the heartbeats, the health checks and of sending
+// the notification.
+func (w *WatchdogPinger) tick(ctx context.Context) []error {
+	errs := append(w.stalled(), w.check(ctx)...)
+	if len(errs) == 0 {
+		ping := w.ping
+		if w.failing {
+			ping = w.recovered
+		}
+		if err := ping(ctx); err != nil {
+			return []error{err}
+		}
+		w.failing = false
+		return nil
+	}
+
+	w.failing = true
+	if err := w.fail(ctx, errs[0]); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// run sends pings until ctx is done.
+func (w *WatchdogPinger) run(ctx context.Context) {
+	defer close(w.done)
+	defer func() {
+		envPingers.Lock()
+		delete(envPingers.m, w)
+		envPingers.Unlock()
+	}()
+
+	// The next ping is scheduled from when the last one was due, so the
+	// time taken by the health checks does not add up to the interval.
+	due := time.Now()
+	for {
+		wait := w.next() - time.Since(due)
+		if wait < 0 {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case due = <-w.opts.After(wait):
+		case <-w.reset:
+			due = time.Now()
 		}
-	}(interval)
 
-	return active, interval, err
+		errs := w.tick(ctx)
+		if ctx.Err() != nil || w.opts.OnError == nil {
+			continue
+		}
+		for _, err := range errs {
+			w.opts.OnError(err)
+		}
+	}
+}
+
+// Stop stops sending pings and waits for the goroutine sending them to exit.
+// Calling Stop on a nil *WatchdogPinger does nothing.
+func (w *WatchdogPinger) Stop() {
+	if w == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
 }
@@ -1,8 +1,13 @@
 package systemd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -45,3 +50,370 @@ func TestWatchdogActive(t *testing.T) {
 		}
 	}
 }
+
+// fakeClock implements WatchdogOptions.After, recording the requested
+// durations and firing only when tick is called, and WatchdogOptions.Now,
+// which only moves when advance is called.
+type fakeClock struct {
+	waits chan time.Duration
+	fire  chan time.Time
+
+	mtx sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{waits: make(chan time.Duration, 100), fire: make(chan time.Time), now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits <- d
+	return c.fire
+}
+
+// tick waits for the pinger to wait and then fires the timer. It returns the
+// duration the pinger waited for.
+func (c *fakeClock) tick(t *testing.T) time.Duration {
+	select {
+	case d := <-c.waits:
+		c.fire <- time.Now()
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the pinger")
+		return 0
+	}
+}
+
+func TestStartWatchdog(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	os.Unsetenv("WATCHDOG_PID")
+	os.Unsetenv("WATCHDOG_USEC")
+	if w, err := StartWatchdog(context.Background(), nil); w != nil || err != nil {
+		t.Errorf("StartWatchdog() with inactive watchdog = %v, %v", w, err)
+	}
+
+	errs := make(chan error, 10)
+	clock := newFakeClock()
+	w, err := StartWatchdog(context.Background(), &WatchdogOptions{
+		Interval: time.Second,
+		Jitter:   100 * time.Millisecond,
+		OnError:  func(err error) { errs <- err },
+		After:    clock.After,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+		t.Errorf("Got %q, expected initial ping", msg)
+	}
+
+	for i := 0; i < 3; i++ {
+		d := clock.tick(t)
+		if d > time.Second || d <= 900*time.Millisecond {
+			t.Errorf("Waited %v, expected between 900ms and 1s", d)
+		}
+		if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+			t.Errorf("Got %q, expected ping", msg)
+		}
+	}
+
+	// Pings to a vanished socket are reported.
+	conn.Close()
+	clock.tick(t)
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("OnError called with nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("OnError not called")
+	}
+
+	// Stop must return, even though the pinger is waiting for the clock.
+	w.Stop()
+}
+
+func TestStartWatchdogInvalid(t *testing.T) {
+	var testcases = []WatchdogOptions{
+		{Interval: -time.Second},
+		{Interval: time.Second, Jitter: time.Second},
+		{Interval: time.Second, Jitter: -time.Second},
+	}
+
+	for _, tc := range testcases {
+		if w, err := StartWatchdog(context.Background(), &tc); err == nil {
+			w.Stop()
+			t.Errorf("StartWatchdog(%+v) succeeded", tc)
+		}
+	}
+}
+
+func TestWatchdogHealthChecks(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	// healthy is accessed atomically.
+	var healthy int32 = 1
+	hang := make(chan struct{})
+	defer close(hang)
+
+	for _, trigger := range []bool{false, true} {
+		errs := make(chan error, 10)
+		clock := newFakeClock()
+		w, err := StartWatchdog(context.Background(), &WatchdogOptions{
+			Interval: time.Second,
+			OnError:  func(err error) { errs <- err },
+			After:    clock.After,
+			Trigger:  trigger,
+			HealthChecks: []HealthCheck{
+				{Name: "db", Check: func(ctx context.Context) error {
+					if atomic.LoadInt32(&healthy) == 0 {
+						return errors.New("connection lost")
+					}
+					return nil
+				}},
+				{Name: "handler", Timeout: 10 * time.Millisecond, Check: func(ctx context.Context) error {
+					if atomic.LoadInt32(&healthy) == 0 {
+						<-hang
+					}
+					return nil
+				}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+			t.Errorf("Got %q, expected initial ping", msg)
+		}
+
+		atomic.StoreInt32(&healthy, 1)
+		clock.tick(t)
+		if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+			t.Errorf("Got %q, expected ping", msg)
+		}
+
+		atomic.StoreInt32(&healthy, 0)
+		clock.tick(t)
+		want := "STATUS=Health check db failed: connection lost"
+		if trigger {
+			want += "\nWATCHDOG=trigger"
+		}
+		if msg, _ := readNotify(t, conn); msg != want {
+			t.Errorf("Got %q, expected %q", msg, want)
+		}
+
+		got := map[string]bool{}
+		for i := 0; i < 2; i++ {
+			select {
+			case err := <-errs:
+				if he, ok := err.(*HealthCheckError); ok {
+					got[he.Name] = true
+				} else {
+					t.Errorf("Unexpected error %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("OnError not called")
+			}
+		}
+		if !got["db"] || !got["handler"] {
+			t.Errorf("Expected errors of db and handler, got %v", got)
+		}
+
+		atomic.StoreInt32(&healthy, 1)
+		clock.tick(t)
+		if msg, _ := readNotify(t, conn); msg != "STATUS=\nWATCHDOG=1" {
+			t.Errorf("Got %q, expected cleared status and ping", msg)
+		}
+		clock.tick(t)
+		if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+			t.Errorf("Got %q, expected ping", msg)
+		}
+		w.Stop()
+	}
+}
+
+func TestWatchdogSlowCheck(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	hang := make(chan struct{})
+	defer close(hang)
+
+	errs := make(chan error, 10)
+	clock := newFakeClock()
+	w, err := StartWatchdog(context.Background(), &WatchdogOptions{
+		Interval: 400 * time.Millisecond,
+		OnError:  func(err error) { errs <- err },
+		After:    clock.After,
+		HealthChecks: []HealthCheck{
+			{Name: "slow", Check: func(ctx context.Context) error {
+				<-hang
+				return nil
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+		t.Errorf("Got %q, expected initial ping", msg)
+	}
+
+	start := time.Now()
+	clock.tick(t)
+	select {
+	case err := <-errs:
+		if he, ok := err.(*HealthCheckError); !ok || he.Err != context.DeadlineExceeded {
+			t.Errorf("Got %v, expected timeout of health check", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError not called")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d >= 400*time.Millisecond {
+		t.Errorf("Health check timed out after %v, expected a quarter of the interval", d)
+	}
+	readNotify(t, conn)
+
+	// The time taken by the check is subtracted from the next wait.
+	if d := clock.tick(t); d > 300*time.Millisecond {
+		t.Errorf("Waited %v, expected at most 300ms", d)
+	}
+}
+
+func TestWatchdogSetTimeout(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	os.Setenv("WATCHDOG_PID", fmt.Sprint(os.Getpid()))
+	os.Setenv("WATCHDOG_USEC", "2000000")
+	defer os.Unsetenv("WATCHDOG_PID")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	clock := newFakeClock()
+	w, err := StartWatchdog(context.Background(), &WatchdogOptions{After: clock.After})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+		t.Errorf("Got %q, expected initial ping", msg)
+	}
+	// The time taken by the last ping is subtracted from the wait.
+	if d := <-clock.waits; d > time.Second || d < time.Second-100*time.Millisecond {
+		t.Errorf("Waiting %v, expected 1s", d)
+	}
+
+	// The pinger follows the package-level function, as it uses the
+	// environment.
+	if err = NotifyWatchdogUsec(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"WATCHDOG_USEC=10000000", "WATCHDOG=1"} {
+		if msg, _ := readNotify(t, conn); msg != want {
+			t.Errorf("Got %q, expected %q", msg, want)
+		}
+	}
+	if d := <-clock.waits; d > 5*time.Second || d < 5*time.Second-100*time.Millisecond {
+		t.Errorf("Waiting %v, expected 5s", d)
+	}
+	if iv := w.Interval(); iv != 5*time.Second {
+		t.Errorf("Interval() = %v, expected 5s", iv)
+	}
+
+	if err = w.SetTimeout(context.Background(), 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"WATCHDOG_USEC=4000000", "WATCHDOG=1"} {
+		if msg, _ := readNotify(t, conn); msg != want {
+			t.Errorf("Got %q, expected %q", msg, want)
+		}
+	}
+	if d := <-clock.waits; d > 2*time.Second || d < 2*time.Second-100*time.Millisecond {
+		t.Errorf("Waiting %v, expected 2s", d)
+	}
+
+	if err = w.SetTimeout(context.Background(), 0); err == nil {
+		t.Errorf("SetTimeout(0) succeeded")
+	}
+
+	if err = NotifyWatchdogTrigger(); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := readNotify(t, conn); msg != "WATCHDOG=trigger" {
+		t.Errorf("Got %q, expected WATCHDOG=trigger", msg)
+	}
+}
+
+func TestWatchdogHeartbeat(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	errs := make(chan error, 10)
+	clock := newFakeClock()
+	w, err := StartWatchdog(context.Background(), &WatchdogOptions{
+		Interval: time.Second,
+		OnError:  func(err error) { errs <- err },
+		After:    clock.After,
+		Now:      clock.Now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+		t.Errorf("Got %q, expected initial ping", msg)
+	}
+
+	fresh := w.Heartbeat("fresh", time.Hour)
+	defer fresh.Stop()
+	worker := w.Heartbeat("worker", time.Minute)
+
+	clock.tick(t)
+	if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+		t.Errorf("Got %q, expected ping", msg)
+	}
+
+	clock.advance(2 * time.Minute)
+	clock.tick(t)
+	if msg, _ := readNotify(t, conn); !strings.HasPrefix(msg, "STATUS=worker stalled") {
+		t.Errorf("Got %q, expected stalled worker", msg)
+	}
+	select {
+	case err := <-errs:
+		if se, ok := err.(*StalledError); !ok || se.Name != "worker" {
+			t.Errorf("Unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError not called")
+	}
+
+	worker.Tick()
+	clock.tick(t)
+	if msg, _ := readNotify(t, conn); msg != "STATUS=\nWATCHDOG=1" {
+		t.Errorf("Got %q, expected ping after Tick", msg)
+	}
+
+	clock.advance(2 * time.Minute)
+	worker.Stop()
+	clock.tick(t)
+	if msg, _ := readNotify(t, conn); msg != "WATCHDOG=1" {
+		t.Errorf("Got %q, expected ping after Stop", msg)
+	}
+}